@@ -1,12 +1,75 @@
 #!/usr/bin/env python3
-"""Dynamic Ansible inventory from Pulumi stack outputs."""
+"""Dynamic Ansible inventory from Pulumi stack outputs.
+
+Everything the inventory needs comes from the "antarctica" stack output,
+validated against pulumi_output.schema.json (generated from the Go Outputs
+struct). An object of another schema_version is refused rather than misread.
+"""
 import json
 import os
 import sys
 
+SCHEMA_FILE = os.path.join(os.path.dirname(os.path.abspath(__file__)), "pulumi_output.schema.json")
+
+
+def load_outputs(pulumi_output):
+    """Return the validated "antarctica" output object."""
+    outputs = pulumi_output.get("antarctica")
+    if outputs is None:
+        sys.exit("pulumi_output.json has no 'antarctica' object; re-run `mise run deploy:infra`")
+
+    with open(SCHEMA_FILE) as f:
+        schema = json.load(f)
+
+    expected = schema["properties"]["schema_version"]["const"]
+    if outputs.get("schema_version") != expected:
+        sys.exit(
+            f"pulumi_output.json has schema_version {outputs.get('schema_version')}, "
+            f"this inventory expects {expected}; update this checkout or re-run `mise run deploy:infra`"
+        )
+
+    try:
+        import jsonschema
+    except ImportError:
+        # Without jsonschema, at least make sure no key is missing.
+        missing = [key for key in schema["required"] if key not in outputs]
+        if missing:
+            sys.exit(f"pulumi_output.json is missing {', '.join(missing)}")
+        return outputs
+
+    try:
+        jsonschema.validate(outputs, schema)
+    except jsonschema.ValidationError as e:
+        path = ".".join(str(p) for p in e.absolute_path) or "<root>"
+        sys.exit(f"pulumi_output.json does not match its schema at {path}: {e.message}")
+    return outputs
+
+
+def pinned_host_key_vars(known_hosts, output_file):
+    """Write the pinned host keys and return vars enforcing strict checking.
+
+    ansible.cfg disables host key checking globally; the per-host var turns
+    it back on, and UserKnownHostsFile limits trust to the pinned keys. The
+    keys are written next to the outputs they came from.
+    """
+    known_hosts_file = os.path.join(os.path.dirname(os.path.abspath(output_file)), "known_hosts")
+    with open(known_hosts_file, "w") as f:
+        f.write(known_hosts)
+
+    return {
+        "ansible_host_key_checking": True,
+        "ansible_ssh_common_args": (
+            f"-o StrictHostKeyChecking=yes -o UserKnownHostsFile={known_hosts_file}"
+        ),
+    }
+
 
 def main():
-    output_file = os.path.join(os.path.dirname(__file__), "pulumi_output.json")
+    # antarctica-infra points this at another stack's outputs, e.g. a
+    # preview environment's, without touching the shared file.
+    output_file = os.environ.get("ANTARCTICA_PULUMI_OUTPUT") or os.path.join(
+        os.path.dirname(__file__), "pulumi_output.json"
+    )
 
     if not os.path.exists(output_file):
         # Return empty inventory if no Pulumi output exists
@@ -16,8 +79,32 @@ def main():
     with open(output_file) as f:
         pulumi_output = json.load(f)
 
-    vm_ip = pulumi_output.get("vm_ip", "")
-    vm_hostname = pulumi_output.get("vm_hostname", "antarctica")
+    outputs = load_outputs(pulumi_output)
+    vm_ip = outputs["vm_ip"]
+    vm_hostname = outputs["vm_hostname"]
+
+    hostvars = {
+        "ansible_host": vm_ip,
+        "ansible_user": "deploy",
+        "ansible_ssh_private_key_file": "~/.ssh/antarctica_ed25519",
+        "ansible_python_interpreter": "/usr/bin/python3",
+        "base_pending_fs_grow": outputs["pending_fs_grow"],
+        "caddy_acme_dns_credentials": outputs["acme_dns_credentials"],
+        "caddy_acme_dns_project": outputs["acme_dns_project"],
+        "caddy_pki_item": outputs["pki_item"],
+        "caddy_pki_ca_certificate": outputs["pki_ca_certificate"],
+        "forgejo_mailer_relay": outputs["mail"]["relay"],
+        "forgejo_mailer_from": outputs["mail"]["from"],
+    }
+
+    # Stacks provisioned with pinned host keys export a ready known_hosts.
+    known_hosts = outputs["ssh_known_hosts"]
+    if known_hosts:
+        hostvars.update(pinned_host_key_vars(known_hosts, output_file))
+
+    # A preview serves its sites under its own prefix (pr-42.dev.nerds.run).
+    if outputs["service_domain"]:
+        hostvars["caddy_domain"] = outputs["service_domain"]
 
     inventory = {
         "antarctica": {
@@ -25,12 +112,7 @@ def main():
         },
         "_meta": {
             "hostvars": {
-                vm_hostname: {
-                    "ansible_host": vm_ip,
-                    "ansible_user": "deploy",
-                    "ansible_ssh_private_key_file": "~/.ssh/antarctica_ed25519",
-                    "ansible_python_interpreter": "/usr/bin/python3",
-                }
+                vm_hostname: hostvars,
             }
         },
     }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/capacity"
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// errOvercommit makes the command exit non-zero after the report has been
+// printed.
+var errOvercommit = errors.New("the node cannot take the VM within the configured overcommit ratios")
+
+// runCapacity reports whether the Proxmox node can take the VM as the
+// stack config sizes it.
+func runCapacity(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("capacity", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	stack, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	return checkCapacity(ctx, stack, os.Stdout)
+}
+
+// checkCapacity evaluates the stack config against the live node and
+// prints the report. Only a refused resource is an error.
+func checkCapacity(ctx context.Context, stack auto.Stack, w io.Writer) error {
+	cfg, err := loadConfig(ctx, stack)
+	if err != nil {
+		return err
+	}
+	client, err := cfg.proxmoxClient()
+	if err != nil {
+		return err
+	}
+
+	report, err := evaluateCapacity(ctx, client, cfg)
+	if err != nil {
+		return err
+	}
+	report.Print(w)
+	if report.Level() == capacity.Refuse {
+		return errOvercommit
+	}
+	return nil
+}
+
+// evaluateCapacity reads the node, its VMs and the storage pool.
+func evaluateCapacity(ctx context.Context, client *pveapi.Client, cfg stackConfig) (*capacity.Report, error) {
+	vm := cfg.VM
+	status, err := client.GetNodeStatus(ctx, vm.Node)
+	if err != nil {
+		return nil, fmt.Errorf("reading node %s: %w", vm.Node, err)
+	}
+	vms, err := client.ListVMs(ctx, vm.Node)
+	if err != nil {
+		return nil, fmt.Errorf("listing VMs on %s: %w", vm.Node, err)
+	}
+	pool, err := client.GetStorageStatus(ctx, vm.Node, vm.StoragePool)
+	if err != nil {
+		return nil, fmt.Errorf("reading storage pool %s: %w", vm.StoragePool, err)
+	}
+
+	req := capacity.Request{
+		VMID:        vm.VMID,
+		VCPUs:       vm.CPUCores * vm.CPUSockets,
+		MemoryMB:    vm.MemoryMB,
+		DiskGB:      vm.BootDiskGB + vm.DataDiskGB,
+		StoragePool: vm.StoragePool,
+	}
+	current, err := client.GetVMConfig(ctx, vm.Node, vm.VMID)
+	switch {
+	case err == nil:
+		req.CurrentDiskGB = pveapi.DiskSizeGB(current.SCSI0) + pveapi.DiskSizeGB(current.SCSI1)
+	case !errors.Is(err, pveapi.ErrNotFound):
+		return nil, fmt.Errorf("reading VM %d: %w", vm.VMID, err)
+	}
+
+	return capacity.Evaluate(capacity.Node{
+		Name:    vm.Node,
+		Status:  status,
+		VMs:     vms,
+		Storage: pool,
+	}, req, cfg.Capacity), nil
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/acme"
+	"github.com/nerdsrun/antarctica/infra/pkg/deploy"
+	"github.com/nerdsrun/antarctica/infra/pkg/outputs"
+	"github.com/nerdsrun/antarctica/infra/pkg/remote"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+)
+
+// runDeploy runs `pulumi up` and/or an Ansible playbook under the deploy
+// lock and appends an audit record. `pulumi up` only runs once the
+// Proxmox node has the capacity for the VM and a preview passes the
+// policy pack. Arguments after the flags (or after "--") are passed to
+// ansible-playbook.
+func runDeploy(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("deploy", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	var vf vmFlags
+	vf.register(fs)
+	var pf policyFlags
+	pf.register(fs)
+	playbook := fs.String("playbook", "site.yml", "playbook under ansible/playbooks to run after `pulumi up`; empty skips Ansible")
+	skipInfra := fs.Bool("skip-infra", false, "skip `pulumi up` and only run the playbook")
+	forceUnlock := fs.Bool("force-unlock", false, "replace a stale deploy lock left by a crashed deploy")
+	skipCapacity := fs.Bool("skip-capacity", false, "skip the Proxmox capacity check before `pulumi up`")
+	withoutVMLock := fs.Bool("without-vm-lock", false, "update a deployed stack whose VM is unreachable (e.g. to rebuild it), relying on the Pulumi stack lock")
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ansibleArgs := fs.Args()
+	if *skipInfra && *playbook == "" {
+		return errors.New("nothing to do: -skip-infra with an empty -playbook")
+	}
+	pack, cleanup, err := pf.pack(sf.dir, sf.stack)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	stack, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	// A stack that was never deployed (or predates the current output
+	// schema) has no usable outputs until `pulumi up` writes them.
+	out, err := loadOutputs(ctx, stack)
+	if err != nil && *skipInfra {
+		return err
+	}
+
+	started := time.Now()
+	id := whoIsDeploying(filepath.Dir(sf.dir))
+	rec := deploy.Record{
+		ID:        deploy.NewID(started),
+		User:      id.user,
+		Email:     id.email,
+		Host:      id.host,
+		GitSHA:    id.sha,
+		GitDirty:  id.dirty,
+		Stack:     sf.stack,
+		Command:   deployCommand(*skipInfra, *playbook),
+		StartedAt: started,
+	}
+	holder := deploy.Holder{
+		ID:         rec.ID,
+		User:       rec.User,
+		Email:      rec.Email,
+		Host:       rec.Host,
+		GitSHA:     rec.GitSHA,
+		Stack:      rec.Stack,
+		Command:    rec.Command,
+		AcquiredAt: started,
+	}
+
+	// The VM lock is taken as soon as the VM is reachable: before
+	// `pulumi up` normally, after it when the VM is being created.
+	var client *remote.Client
+	lock := func() error {
+		if client != nil {
+			return nil
+		}
+		c, err := vf.dial(ctx, out)
+		if err != nil {
+			return err
+		}
+		if err := deploy.Acquire(ctx, c, holder, *forceUnlock); err != nil {
+			c.Close()
+			return err
+		}
+		client = c
+		fmt.Printf("Deploy lock %s taken on the VM\n", rec.ID)
+		return nil
+	}
+
+	// Release the lock and record the outcome even when interrupted. A
+	// deploy turned away by the lock never started and is not recorded;
+	// once `pulumi up` has run, the record is kept whatever happens next.
+	upRan := false
+	defer func() {
+		if client == nil && deploy.IsLocked(err) && !upRan {
+			return
+		}
+		rec.FinishedAt = time.Now()
+		rec.Status = deploy.Succeeded
+		if err != nil {
+			rec.Status = deploy.Failed
+			rec.Error = err.Error()
+		}
+		ctx := context.WithoutCancel(ctx)
+		if client == nil && upRan {
+			// The stack changed without the VM lock held (e.g. another
+			// deploy took it meanwhile): store the record anyway, leaving
+			// that deploy's lock alone.
+			if c, dialErr := vf.dial(ctx, out); dialErr == nil {
+				defer c.Close()
+				if appendErr := deploy.Append(ctx, c, rec); appendErr == nil {
+					return
+				}
+			}
+		}
+		finishDeploy(ctx, client, rec)
+	}()
+
+	if err := lock(); err != nil {
+		if deploy.IsLocked(err) || *skipInfra {
+			return err
+		}
+		// Only a stack without outputs, whose VM is yet to be created, may
+		// be updated before the VM lock is held; otherwise another deploy
+		// could be running against the VM.
+		if out != nil && !*withoutVMLock {
+			return fmt.Errorf("taking the deploy lock: %w (pass -without-vm-lock to update the stack anyway)", err)
+		}
+		fmt.Fprintf(os.Stderr, "warning: VM not reachable (%v); relying on the Pulumi stack lock until it is\n", err)
+	}
+
+	if !*skipInfra {
+		// The capacity summary is advisory unless the node is known to be
+		// short; without Proxmox credentials it is skipped.
+		if !*skipCapacity {
+			if err := checkCapacity(ctx, stack, os.Stdout); errors.Is(err, errOvercommit) {
+				return fmt.Errorf("%w (pass -skip-capacity to deploy anyway)", err)
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: capacity check skipped: %v\n", err)
+			}
+		}
+		if err := checkPolicies(ctx, stack, pack, os.Stdout); err != nil {
+			return err
+		}
+		summary, raw, err := pulumiUp(ctx, stack, rec, pack)
+		upRan = true
+		rec.Pulumi = summary
+		if err != nil {
+			return err
+		}
+		if out, err = outputs.Decode(raw[outputs.Key].Value); err != nil {
+			return err
+		}
+		if err := storeACMEKey(raw); err != nil {
+			return err
+		}
+		if err := writeInventoryOutputs(filepath.Join(sf.dir, "..", "ansible", "inventory"), raw, out); err != nil {
+			return err
+		}
+		if err := lock(); err != nil {
+			if deploy.IsLocked(err) || *playbook != "" {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "warning: VM not reachable (%v); the audit record is printed below instead\n", err)
+		}
+	}
+
+	if *playbook != "" {
+		summary, err := runPlaybook(ctx, filepath.Join(sf.dir, "..", "ansible"), *playbook, "inventory/", nil, ansibleArgs)
+		rec.Ansible = summary
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deployCommand describes a deploy for the lock and audit trail.
+func deployCommand(skipInfra bool, playbook string) string {
+	var steps []string
+	if !skipInfra {
+		steps = append(steps, "up")
+	}
+	if playbook != "" {
+		steps = append(steps, playbook)
+	}
+	return strings.Join(steps, " + ")
+}
+
+// pulumiUp updates the stack with the policy pack. Pulumi holds the stack
+// lock for the duration of the update; a second concurrent `up` fails
+// fast.
+func pulumiUp(ctx context.Context, stack auto.Stack, rec deploy.Record, pack policyPack) (*deploy.PulumiSummary, auto.OutputMap, error) {
+	msg := fmt.Sprintf("deploy %s by %s at %.12s", rec.ID, rec.User, rec.GitSHA)
+	res, err := stack.Up(ctx, optup.ProgressStreams(os.Stdout), optup.Message(msg), pack.upOption())
+
+	summary := &deploy.PulumiSummary{
+		Version: res.Summary.Version,
+		Result:  res.Summary.Result,
+	}
+	if res.Summary.ResourceChanges != nil {
+		summary.ResourceChanges = *res.Summary.ResourceChanges
+	}
+	if err != nil {
+		if summary.Result == "" {
+			summary.Result = deploy.Failed
+		}
+		if auto.IsConcurrentUpdateError(err) {
+			return summary, nil, fmt.Errorf("stack %s is being updated by another deploy (Pulumi stack lock)", stack.Name())
+		}
+		return summary, nil, fmt.Errorf("updating stack %s: %w", stack.Name(), err)
+	}
+	return summary, res.Outputs, nil
+}
+
+// storeACMEKey writes the DNS-01 key an update exported to 1Password.
+// Keeping this out of the program means previews and failed updates never
+// touch the vault.
+func storeACMEKey(raw auto.OutputMap) error {
+	key, ok := raw[acme.KeyOutput]
+	if !ok {
+		return nil
+	}
+	return acme.StoreKey(key.Value)
+}
+
+// writeInventoryOutputs writes the stack outputs where the Ansible
+// inventory script reads them, as `pulumi stack output --json` would, plus
+// the pinned known_hosts for the ops:* tasks.
+func writeInventoryOutputs(dir string, raw auto.OutputMap, out *outputs.Outputs) error {
+	plain := make(map[string]interface{}, len(raw))
+	for key, o := range raw {
+		if o.Secret {
+			plain[key] = "[secret]"
+			continue
+		}
+		plain[key] = o.Value
+	}
+	data, err := json.MarshalIndent(plain, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding stack outputs: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pulumi_output.json"), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing stack outputs: %w", err)
+	}
+
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	if out.SSHKnownHosts == "" {
+		if err := os.Remove(knownHostsFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(knownHostsFile, []byte(out.SSHKnownHosts), 0o644)
+}
+
+// runPlaybook runs ansible-playbook against inventory (relative to dir)
+// with env added to the environment, streaming its output, and summarises
+// the PLAY RECAP.
+func runPlaybook(ctx context.Context, dir, playbook, inventory string, env, args []string) (*deploy.AnsibleSummary, error) {
+	cmdArgs := append([]string{filepath.Join("playbooks", playbook), "-i", inventory}, args...)
+	cmd := exec.CommandContext(ctx, "ansible-playbook", cmdArgs...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var out bytes.Buffer
+	cmd.Stdout = io.MultiWriter(os.Stdout, &out)
+	cmd.Stderr = os.Stderr
+
+	runErr := cmd.Run()
+	summary := &deploy.AnsibleSummary{
+		Playbook: playbook,
+		Args:     args,
+		Result:   deploy.Succeeded,
+		Recap:    deploy.ParseRecap(out.String()),
+	}
+	if runErr != nil {
+		summary.Result = deploy.Failed
+		return summary, fmt.Errorf("ansible-playbook %s: %w", playbook, runErr)
+	}
+	return summary, nil
+}
+
+// finishDeploy appends the audit record and releases the lock. Without a
+// VM connection the record is printed so it is not lost.
+func finishDeploy(ctx context.Context, client *remote.Client, rec deploy.Record) {
+	if client == nil {
+		line, _ := json.Marshal(rec)
+		fmt.Fprintf(os.Stderr, "audit record (not stored, VM unreachable): %s\n", line)
+		return
+	}
+	defer client.Close()
+
+	if err := deploy.Append(ctx, client, rec); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	if err := deploy.Release(ctx, client, rec.ID); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v; remove %s/lock by hand\n", err, deploy.Dir)
+	}
+}
+
+// deployer identifies who is deploying what.
+type deployer struct {
+	user, email, host string
+	sha               string
+	dirty             bool
+}
+
+// whoIsDeploying collects the local user, machine and git state of repo.
+func whoIsDeploying(repo string) deployer {
+	d := deployer{user: os.Getenv("USER"), sha: "unknown"}
+	if u, err := user.Current(); err == nil {
+		d.user = u.Username
+	}
+	d.host, _ = os.Hostname()
+
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	d.email = git("config", "user.email")
+	if sha := git("rev-parse", "HEAD"); sha != "" {
+		d.sha = sha
+	}
+	d.dirty = git("status", "--porcelain") != ""
+	return d
+}
+
+// runAudit prints the current lock holder and the latest audit records.
+func runAudit(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	var vf vmFlags
+	vf.register(fs)
+	limit := fs.Int("n", 20, "number of records to show")
+	format := fs.String("format", "table", "output format: table or json")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown -format %q (want table or json)", *format)
+	}
+
+	stack, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	out, err := loadOutputs(ctx, stack)
+	if err != nil && vf.host == "" {
+		return err
+	}
+	client, err := vf.dial(ctx, out)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	holder, err := deploy.Current(ctx, client)
+	if err != nil {
+		return err
+	}
+	records, err := deploy.Read(ctx, client, *limit)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Lock    *deploy.Holder  `json:"lock"`
+			Records []deploy.Record `json:"records"`
+		}{holder, records})
+	}
+
+	if holder != nil {
+		fmt.Printf("Locked by %s\n\n", holder)
+	} else {
+		fmt.Printf("Not locked\n\n")
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STARTED\tUSER\tCOMMIT\tCOMMAND\tSTATUS\tCHANGES\tTIME")
+	for _, r := range records {
+		sha := r.GitSHA
+		if len(sha) > 12 {
+			sha = sha[:12]
+		}
+		if r.GitDirty {
+			sha += "+"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.StartedAt.Local().Format("2006-01-02 15:04"), r.User, sha, r.Command,
+			strings.ToUpper(r.Status), changeSummary(r), r.FinishedAt.Sub(r.StartedAt).Round(time.Second))
+	}
+	return tw.Flush()
+}
+
+// changeSummary condenses what a deploy changed into one column.
+func changeSummary(r deploy.Record) string {
+	var parts []string
+	if p := r.Pulumi; p != nil {
+		var ops []string
+		for _, op := range []string{"create", "update", "replace", "delete"} {
+			if n := p.ResourceChanges[op]; n > 0 {
+				ops = append(ops, fmt.Sprintf("%s=%d", op, n))
+			}
+		}
+		if len(ops) == 0 {
+			ops = append(ops, "no changes")
+		}
+		parts = append(parts, "pulumi: "+strings.Join(ops, " "))
+	}
+	if a := r.Ansible; a != nil {
+		changed := 0
+		for _, c := range a.Recap {
+			changed += c.Changed
+		}
+		parts = append(parts, fmt.Sprintf("ansible: changed=%d", changed))
+	}
+	return strings.Join(parts, ", ")
+}
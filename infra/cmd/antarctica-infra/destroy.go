@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/deploy"
+	"github.com/nerdsrun/antarctica/infra/pkg/remote"
+	"github.com/nerdsrun/antarctica/infra/pkg/stackconfig"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+)
+
+// Config key dropping Protect and RetainOnDelete from the VM and records.
+const allowReplaceKey = stackconfig.Namespace + ":allow_replace"
+
+// runDestroy tears the stack down under the deploy lock. The VM and its
+// PTR and mail records are protected and retained on delete, so a plain
+// `pulumi destroy` fails on them, or would only forget them. An update
+// with allow_replace set drops both options first; it runs with
+// -expect-no-changes, so a pending change of the program is refused
+// instead of applied unreviewed. allow_replace is restored afterwards.
+//
+// The audit trail lives on the VM, so the record of a successful destroy
+// is printed instead of stored.
+func runDestroy(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("destroy", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	var vf vmFlags
+	vf.register(fs)
+	var pf policyFlags
+	pf.register(fs)
+	confirm := fs.String("confirm", "", "name of the stack to destroy, as a safeguard")
+	forceUnlock := fs.Bool("force-unlock", false, "replace a stale deploy lock left by a crashed deploy")
+	withoutVMLock := fs.Bool("without-vm-lock", false, "destroy a deployed stack whose VM is unreachable, relying on the Pulumi stack lock")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *confirm != sf.stack {
+		return fmt.Errorf("refusing to destroy stack %s: pass -confirm %s", sf.stack, sf.stack)
+	}
+	// The protection is dropped on purpose here.
+	pf.disable += ",vm-protected"
+	pack, cleanup, err := pf.pack(sf.dir, sf.stack)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	stack, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	out, _ := loadOutputs(ctx, stack)
+
+	started := time.Now()
+	id := whoIsDeploying(filepath.Dir(sf.dir))
+	rec := deploy.Record{
+		ID:        deploy.NewID(started),
+		User:      id.user,
+		Email:     id.email,
+		Host:      id.host,
+		GitSHA:    id.sha,
+		GitDirty:  id.dirty,
+		Stack:     sf.stack,
+		Command:   "destroy",
+		StartedAt: started,
+	}
+
+	var client *remote.Client
+	if c, dialErr := vf.dial(ctx, out); dialErr != nil {
+		if out != nil && !*withoutVMLock {
+			return fmt.Errorf("taking the deploy lock: %w (pass -without-vm-lock to destroy anyway)", dialErr)
+		}
+		fmt.Fprintf(os.Stderr, "warning: VM not reachable (%v); relying on the Pulumi stack lock\n", dialErr)
+	} else {
+		holder := deploy.Holder{
+			ID:         rec.ID,
+			User:       rec.User,
+			Email:      rec.Email,
+			Host:       rec.Host,
+			GitSHA:     rec.GitSHA,
+			Stack:      rec.Stack,
+			Command:    rec.Command,
+			AcquiredAt: started,
+		}
+		if err := deploy.Acquire(ctx, c, holder, *forceUnlock); err != nil {
+			c.Close()
+			return err
+		}
+		client = c
+		fmt.Printf("Deploy lock %s taken on the VM\n", rec.ID)
+	}
+
+	destroyed := false
+	defer func() {
+		rec.FinishedAt = time.Now()
+		rec.Status = deploy.Succeeded
+		if err != nil {
+			rec.Status = deploy.Failed
+			rec.Error = err.Error()
+		}
+		if destroyed && client != nil {
+			// The lock and the audit trail went with the VM.
+			client.Close()
+			client = nil
+		}
+		finishDeploy(context.WithoutCancel(ctx), client, rec)
+	}()
+
+	restore, err := setAllowReplace(ctx, stack)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if restoreErr := restore(); restoreErr != nil && err == nil {
+			err = restoreErr
+		}
+	}()
+
+	fmt.Printf("Dropping the protection of stack %s\n", sf.stack)
+	if _, err := stack.Up(ctx,
+		optup.ExpectNoChanges(),
+		optup.ProgressStreams(os.Stdout),
+		optup.Message(fmt.Sprintf("destroy %s by %s: drop protection", rec.ID, rec.User)),
+		pack.upOption(),
+	); err != nil {
+		return fmt.Errorf("dropping protection (deploy or revert pending changes first): %w", err)
+	}
+
+	res, err := stack.Destroy(ctx, optdestroy.ProgressStreams(os.Stdout),
+		optdestroy.Message(fmt.Sprintf("destroy %s by %s", rec.ID, rec.User)))
+	rec.Pulumi = &deploy.PulumiSummary{Version: res.Summary.Version, Result: res.Summary.Result}
+	if res.Summary.ResourceChanges != nil {
+		rec.Pulumi.ResourceChanges = *res.Summary.ResourceChanges
+	}
+	if err != nil {
+		return fmt.Errorf("destroying stack %s: %w", sf.stack, err)
+	}
+	destroyed = true
+	return nil
+}
+
+// setAllowReplace sets allow_replace on stack and returns a function that
+// puts back the previous value.
+func setAllowReplace(ctx context.Context, stack auto.Stack) (func() error, error) {
+	previous, getErr := stack.GetConfig(ctx, allowReplaceKey)
+	if err := stack.SetConfig(ctx, allowReplaceKey, auto.ConfigValue{Value: "true"}); err != nil {
+		return nil, fmt.Errorf("setting %s: %w", allowReplaceKey, err)
+	}
+	return func() error {
+		ctx := context.WithoutCancel(ctx)
+		var err error
+		if getErr != nil {
+			err = stack.RemoveConfig(ctx, allowReplaceKey)
+		} else {
+			err = stack.SetConfig(ctx, allowReplaceKey, previous)
+		}
+		if err != nil {
+			return fmt.Errorf("restoring %s: %w", allowReplaceKey, err)
+		}
+		return nil
+	}, nil
+}
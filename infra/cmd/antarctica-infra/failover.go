@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/stackconfig"
+	"github.com/nerdsrun/antarctica/infra/pkg/vm"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+// Config key switching the service records to the standby.
+const drActiveKey = stackconfig.Namespace + ":dr_active"
+
+// stackResourceType is the type of the root stack resource, which holds
+// the stack outputs.
+const stackResourceType = "pulumi:pulumi:Stack"
+
+// runFailover points the service records and the exported vm_ip at the
+// standby (or back at the primary with -revert). Only the record sets and
+// the stack outputs are updated, so the run does not depend on the
+// primary VM or its node.
+//
+// The deploy lock lives on the primary VM, which is presumably down, so
+// failover does not take it.
+func runFailover(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("failover", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	var pf policyFlags
+	pf.register(fs)
+	revert := fs.Bool("revert", false, "point the records back at the primary")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	pack, cleanup, err := pf.pack(sf.dir, sf.stack)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	stack, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(ctx, stack)
+	if err != nil {
+		return err
+	}
+	if !cfg.DR.Enabled() {
+		return fmt.Errorf("stack %s has no standby; set %s:dr_node", sf.stack, stackconfig.Namespace)
+	}
+
+	target, ip := "standby", vm.StripCIDR(cfg.DR.IPAddress)
+	if *revert {
+		target, ip = "primary", vm.StripCIDR(cfg.VM.IPAddress)
+	}
+	if cfg.DR.Active == !*revert {
+		fmt.Printf("Stack %s already points at the %s; re-applying the records.\n", sf.stack, target)
+	}
+
+	if err := stack.SetConfig(ctx, drActiveKey, auto.ConfigValue{Value: fmt.Sprint(!*revert)}); err != nil {
+		return fmt.Errorf("setting %s: %w", drActiveKey, err)
+	}
+
+	urns, err := failoverTargets(ctx, stack)
+	if err != nil {
+		return err
+	}
+	if len(urns) < 2 {
+		return errors.New("stack has no DNS record sets to move; is antarctica:gcp_dns_zone set?")
+	}
+
+	fmt.Printf("Moving the service records of %s to the %s (%s)\n", sf.stack, target, ip)
+	if _, err := stack.Up(ctx,
+		optup.Target(urns),
+		optup.ProgressStreams(os.Stdout),
+		optup.Message(fmt.Sprintf("antarctica-infra failover: records to the %s", target)),
+		pack.upOption(),
+	); err != nil {
+		return fmt.Errorf("moving records: %w", err)
+	}
+	if !*revert {
+		fmt.Println("\nStart the standby and reconverge it as the failover_steps output describes.")
+	}
+	return nil
+}
+
+// failoverTargets returns the URNs of the stack resource and the DNS
+// record sets in the stack's state.
+func failoverTargets(ctx context.Context, stack auto.Stack) ([]string, error) {
+	state, err := stack.Export(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("exporting state of stack %s: %w", stack.Name(), err)
+	}
+	var deployment apitype.DeploymentV3
+	if err := json.Unmarshal(state.Deployment, &deployment); err != nil {
+		return nil, fmt.Errorf("decoding state of stack %s: %w", stack.Name(), err)
+	}
+
+	var urns []string
+	for _, res := range deployment.Resources {
+		switch res.Type {
+		case stackResourceType, recordSetResourceType:
+			urns = append(urns, string(res.URN))
+		}
+	}
+	return urns, nil
+}
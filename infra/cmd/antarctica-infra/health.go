@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/health"
+	"github.com/nerdsrun/antarctica/infra/pkg/remote"
+)
+
+// errUnhealthy makes the command exit non-zero once the report is printed.
+var errUnhealthy = errors.New("one or more checks failed")
+
+// healthReport is the JSON form of a health run.
+type healthReport struct {
+	Stack   string          `json:"stack"`
+	Host    string          `json:"host"`
+	Via     string          `json:"via"`
+	Healthy bool            `json:"healthy"`
+	Checks  []health.Result `json:"checks"`
+}
+
+// runHealth checks every Antarctica service, either on the VM over one SSH
+// connection or through Caddy over HTTPS, and exits non-zero on failure.
+func runHealth(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("health", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	via := fs.String("via", "ssh", "transport: ssh (checks on the VM) or https (through Caddy)")
+	format := fs.String("format", "table", "output format: table or json")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout per check")
+	var vf vmFlags
+	vf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown -format %q (want table or json)", *format)
+	}
+
+	stack, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	out, err := loadOutputs(ctx, stack)
+	if err != nil && vf.host == "" {
+		return err
+	}
+	host := vf.address(out)
+
+	var probe health.Probe
+	switch *via {
+	case "ssh":
+		client, err := vf.dial(ctx, out)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		probe = sshProbe(client)
+	case "https":
+		cfg, err := loadConfig(ctx, stack)
+		if err != nil {
+			return err
+		}
+		if cfg.DNSDomain == "" {
+			return fmt.Errorf("stack %s has no dns_domain; use -via ssh", sf.stack)
+		}
+		probe = httpsProbe(cfg.DNSDomain)
+	default:
+		return fmt.Errorf("unknown -via %q (want ssh or https)", *via)
+	}
+
+	results := health.Run(ctx, health.Services, *timeout, probe)
+	report := healthReport{
+		Stack:   sf.stack,
+		Host:    host,
+		Via:     *via,
+		Healthy: health.AllHealthy(results),
+		Checks:  results,
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printHealthTable(report)
+	}
+
+	if !report.Healthy {
+		return errUnhealthy
+	}
+	return nil
+}
+
+// sshProbe runs each service's command on the VM.
+func sshProbe(client *remote.Client) health.Probe {
+	return func(ctx context.Context, svc health.Service) (string, error) {
+		return client.Run(ctx, svc.Command)
+	}
+}
+
+// httpsProbe fetches each exposed service through Caddy. Services Caddy
+// does not expose are skipped; Caddy itself is implicitly covered.
+func httpsProbe(domain string) health.Probe {
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12}},
+	}
+	return func(ctx context.Context, svc health.Service) (string, error) {
+		if svc.Subdomain == "" {
+			return "", health.ErrSkipped
+		}
+		url := fmt.Sprintf("https://%s.%s%s", svc.Subdomain, domain, svc.Path)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		detail := strings.TrimSpace(string(body))
+		if resp.StatusCode/100 != 2 {
+			return detail, fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		return detail, nil
+	}
+}
+
+// printHealthTable writes the report as an aligned table.
+func printHealthTable(r healthReport) {
+	fmt.Printf("Stack %s, host %s, via %s\n\n", r.Stack, r.Host, r.Via)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tTIME\tDETAIL")
+	for _, c := range r.Checks {
+		detail := strings.ReplaceAll(c.Detail, "\n", " ")
+		if len(detail) > 60 {
+			detail = detail[:57] + "..."
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, strings.ToUpper(string(c.Status)),
+			c.Duration.Round(time.Millisecond), detail)
+	}
+	tw.Flush()
+}
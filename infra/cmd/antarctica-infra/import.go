@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/clouddns"
+	"github.com/nerdsrun/antarctica/infra/pkg/dns"
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi"
+	"github.com/nerdsrun/antarctica/infra/pkg/vm"
+)
+
+// Pulumi type tokens of the resources the program creates.
+const (
+	vmResourceType        = "proxmoxve:VM/virtualMachine:VirtualMachine"
+	recordSetResourceType = "gcp:dns/recordSet:RecordSet"
+)
+
+// importSpec is the file format read by `pulumi import --file`.
+type importSpec struct {
+	Resources []importResource `json:"resources"`
+}
+
+// importResource is one entry of an import spec.
+type importResource struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// runImport looks up the live VM and DNS record sets matching the stack
+// config, reports any difference from what the program would create, and
+// writes a Pulumi import spec for the resources that already exist.
+func runImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	out := fs.String("out", "import.json", "path of the generated Pulumi import spec")
+	allowDrift := fs.Bool("allow-drift", false, "write the import spec even when live resources differ from code")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	stack, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(ctx, stack)
+	if err != nil {
+		return err
+	}
+
+	var spec importSpec
+	var drift []string
+
+	// --- Proxmox VM ---
+	pve, err := cfg.proxmoxClient()
+	if err != nil {
+		return err
+	}
+	live, err := pve.GetVMConfig(ctx, cfg.VM.Node, cfg.VM.VMID)
+	switch {
+	case errors.Is(err, pveapi.ErrNotFound):
+		fmt.Printf("VM %d not found on %s; `pulumi up` will create it\n", cfg.VM.VMID, cfg.VM.Node)
+	case err != nil:
+		return err
+	default:
+		fmt.Printf("VM %d (%s) found on %s\n", cfg.VM.VMID, live.Name, cfg.VM.Node)
+		spec.Resources = append(spec.Resources, importResource{
+			Type: vmResourceType,
+			Name: cfg.VM.Hostname,
+			ID:   fmt.Sprintf("%s/%d", cfg.VM.Node, cfg.VM.VMID),
+		})
+		drift = append(drift, compareVM(cfg.VM, live)...)
+	}
+
+	// --- Cloud DNS record sets ---
+	if cfg.GCPDNSZone != "" && cfg.DNSDomain != "" {
+		gcp, err := clouddns.NewFromEnv(cfg.get("gcp:project"))
+		if err != nil {
+			return err
+		}
+		rrsets, err := gcp.ListRecordSets(ctx, cfg.GCPDNSZone)
+		if err != nil {
+			return err
+		}
+		existing := map[string]clouddns.RecordSet{}
+		for _, rs := range rrsets {
+			existing[rs.Name+"/"+rs.Type] = rs
+		}
+
+		records, err := cfg.Records(stack.Workspace().WorkDir())
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			fqdn := rec.FQDN(cfg.DNSDomain)
+			rs, ok := existing[fqdn+"/A"]
+			if !ok {
+				fmt.Printf("DNS %s A not found; `pulumi up` will create it\n", fqdn)
+				continue
+			}
+			fmt.Printf("DNS %s A found: %s\n", fqdn, strings.Join(rs.Rrdatas, ", "))
+			spec.Resources = append(spec.Resources, importResource{
+				Type: recordSetResourceType,
+				Name: rec.ResourceName(),
+				ID:   fmt.Sprintf("projects/%s/managedZones/%s/rrsets/%s/A", gcp.Project, cfg.GCPDNSZone, fqdn),
+			})
+			drift = append(drift, compareRecord(cfg.VM, rs)...)
+		}
+	}
+
+	if len(drift) > 0 {
+		fmt.Println()
+		fmt.Println("Live resources differ from the stack config:")
+		for _, d := range drift {
+			fmt.Printf("  - %s\n", d)
+		}
+		if !*allowDrift {
+			return fmt.Errorf("%d differences; align the stack config or re-run with -allow-drift", len(drift))
+		}
+	}
+
+	if len(spec.Resources) == 0 {
+		fmt.Println("Nothing to import.")
+		return nil
+	}
+
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding import spec: %w", err)
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing import spec: %w", err)
+	}
+
+	fmt.Printf("\nWrote %d resources to %s. Adopt them with:\n", len(spec.Resources), *out)
+	fmt.Printf("  pulumi import --file %s --stack %s --generate-code=false\n", *out, sf.stack)
+	return nil
+}
+
+// compareVM lists every setting where the live VM differs from cfg.
+// Proxmox omits properties left at their default, so empty live values
+// are compared against those defaults.
+func compareVM(cfg vm.Config, live *pveapi.VMConfig) []string {
+	var drift []string
+	check := func(field string, want, got interface{}) {
+		if fmt.Sprint(want) != fmt.Sprint(got) {
+			drift = append(drift, fmt.Sprintf("vm %s: code %v, live %v", field, want, got))
+		}
+	}
+
+	check("name", cfg.Hostname, live.Name)
+	check("cores", cfg.CPUCores, int(live.Cores))
+	check("sockets", cfg.CPUSockets, orDefault(int(live.Sockets), 1))
+	check("memory_mb", cfg.MemoryMB, int(live.Memory))
+	check("cpu_type", cfg.CPUType, orDefault(strings.SplitN(live.CPU, ",", 2)[0], "kvm64"))
+	check("bios", cfg.BIOS, orDefault(live.BIOS, "seabios"))
+	check("machine", cfg.Machine, orDefault(live.Machine, "pc"))
+	check("boot_disk_gb", cfg.BootDiskGB, pveapi.DiskSizeGB(live.SCSI0))
+	check("data_disk_gb", cfg.DataDiskGB, pveapi.DiskSizeGB(live.SCSI1))
+	check("storage_pool", cfg.StoragePool, datastore(live.SCSI0))
+	check("network_bridge", cfg.NetworkBridge, pveapi.PropertyValue(live.Net0, "bridge"))
+	check("ssh_user", cfg.SSHUser, live.CIUser)
+
+	if cfg.IPAddress == "" {
+		check("ip_address", "dhcp", pveapi.PropertyValue(live.IPConfig0, "ip"))
+	} else {
+		check("ip_address", cfg.IPAddress, pveapi.PropertyValue(live.IPConfig0, "ip"))
+		check("gateway", cfg.Gateway, pveapi.PropertyValue(live.IPConfig0, "gw"))
+	}
+	return drift
+}
+
+// compareRecord checks a live A record against the TTL and static IP the
+// program would set. DHCP addresses are only known after provisioning, so
+// their rrdatas are not compared.
+func compareRecord(cfg vm.Config, rs clouddns.RecordSet) []string {
+	var drift []string
+	if rs.TTL != dns.DefaultTTL {
+		drift = append(drift, fmt.Sprintf("dns %s ttl: code %d, live %d", rs.Name, dns.DefaultTTL, rs.TTL))
+	}
+	if cfg.IPAddress != "" {
+		want := vm.StripCIDR(cfg.IPAddress)
+		if len(rs.Rrdatas) != 1 || rs.Rrdatas[0] != want {
+			drift = append(drift, fmt.Sprintf("dns %s rrdatas: code [%s], live %v", rs.Name, want, rs.Rrdatas))
+		}
+	}
+	return drift
+}
+
+// datastore returns the storage ID of a disk property ("sharedx:vm-200-disk-0,size=50G" -> "sharedx").
+func datastore(disk string) string {
+	id, _, _ := strings.Cut(disk, ":")
+	return id
+}
+
+// orDefault substitutes def for the zero value.
+func orDefault[T comparable](v, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,88 @@
+// Command antarctica-infra is the operator CLI for the Antarctica stack.
+//
+// It complements `pulumi up` (driven by the mise deploy:* tasks) with
+// operations that need the stack config alongside the live Proxmox and
+// Cloud DNS APIs. Stacks are opened through the Pulumi automation API, so
+// the pulumi CLI must be installed and logged in.
+//
+// Usage:
+//
+//	antarctica-infra <command> [flags]
+//
+// Run `antarctica-infra <command> -h` for the flags of each command.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+// command is a single antarctica-infra subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+// commands lists every subcommand in the order shown by usage.
+var commands = []command{
+	{"deploy", "Run pulumi up and Ansible under the deploy lock, with an audit record", runDeploy},
+	{"audit", "Show the deploy lock holder and the deploy audit trail", runAudit},
+	{"import", "Adopt an existing Proxmox VM and DNS records into the stack", runImport},
+	{"preview-env", "Create, list and tear down per-PR preview environments", runPreviewEnv},
+	{"policy", "Preview the stack and check it against the infra policy pack", runPolicy},
+	{"capacity", "Check the Proxmox node has room for the VM as configured", runCapacity},
+	{"destroy", "Drop the protection of the stack and destroy it under the deploy lock", runDestroy},
+	{"failover", "Point the service records at the DR standby, or back with -revert", runFailover},
+	{"health", "Check service health on the VM or through Caddy", runHealth},
+	{"schema", "Print or check the JSON Schema of the antarctica stack output", runSchema},
+	{"secrets-check", "Cross-check Ansible op:// references against the secrets manifest", runSecretsCheck},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := cmd.run(ctx, os.Args[2:])
+		stop()
+
+		switch {
+		case err == nil:
+			return
+		case errors.Is(err, flag.ErrHelp):
+			os.Exit(2)
+		default:
+			fmt.Fprintf(os.Stderr, "antarctica-infra %s: %v\n", name, err)
+			os.Exit(1)
+		}
+	}
+
+	if name != "-h" && name != "--help" && name != "help" {
+		fmt.Fprintf(os.Stderr, "antarctica-infra: unknown command %q\n\n", name)
+	}
+	usage()
+	os.Exit(2)
+}
+
+// usage prints the command list to stderr.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: antarctica-infra <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/network"
+	"github.com/nerdsrun/antarctica/infra/pkg/policy"
+	"github.com/nerdsrun/antarctica/infra/pkg/preview"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+)
+
+// errPolicyViolations makes the command exit non-zero after the
+// violations have been printed.
+var errPolicyViolations = errors.New("mandatory policy violations")
+
+// policyPackDir is the policy pack, relative to the Pulumi program.
+const policyPackDir = "policy"
+
+// policyFlags are the flags shared by every command that checks policies.
+type policyFlags struct {
+	disable string
+}
+
+// register adds -disable-policy to fs.
+func (f *policyFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.disable, "disable-policy", "",
+		"comma-separated policies to skip for this run ("+strings.Join(policy.Names(), ", ")+")")
+}
+
+// pack returns the policy pack for a run on stack in the program in dir.
+// Preview environments run with allow_replace, so vm-protected never
+// applies to them. The returned cleanup removes the pack config.
+func (f *policyFlags) pack(dir, stack string) (policyPack, func(), error) {
+	disabled, err := policy.ParseDisabled(f.disable)
+	if err != nil {
+		return policyPack{}, nil, err
+	}
+	if strings.HasPrefix(stack, preview.StackPrefix) {
+		disabled["vm-protected"] = true
+	}
+
+	// pulumi runs in dir, so the pack path must not be relative to ours.
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return policyPack{}, nil, err
+	}
+	data, err := json.Marshal(policy.Config(disabled, network.FirewallPorts))
+	if err != nil {
+		return policyPack{}, nil, err
+	}
+	file, err := os.CreateTemp("", "antarctica-policy-*.json")
+	if err != nil {
+		return policyPack{}, nil, fmt.Errorf("writing policy pack config: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return policyPack{}, nil, fmt.Errorf("writing policy pack config: %w", err)
+	}
+	p := policyPack{path: filepath.Join(dir, policyPackDir), config: file.Name()}
+	return p, func() { os.Remove(p.config) }, nil
+}
+
+// policyPack is the policy pack passed to `pulumi preview` and `pulumi up`.
+type policyPack struct {
+	path string
+	// Pack config file with the disabled policies and the host firewall
+	// ports.
+	config string
+}
+
+// previewOption runs the pack during a preview.
+func (p policyPack) previewOption() optpreview.Option { return previewPolicyPack(p) }
+
+// upOption runs the pack during an update.
+func (p policyPack) upOption() optup.Option { return upPolicyPack(p) }
+
+type previewPolicyPack policyPack
+
+func (p previewPolicyPack) ApplyOption(opts *optpreview.Options) {
+	opts.PolicyPacks = append(opts.PolicyPacks, p.path)
+	opts.PolicyPackConfigs = append(opts.PolicyPackConfigs, p.config)
+}
+
+type upPolicyPack policyPack
+
+func (p upPolicyPack) ApplyOption(opts *optup.Options) {
+	opts.PolicyPacks = append(opts.PolicyPacks, p.path)
+	opts.PolicyPackConfigs = append(opts.PolicyPackConfigs, p.config)
+}
+
+// runPolicy previews the stack with the policy pack.
+func runPolicy(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("policy", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	var pf policyFlags
+	pf.register(fs)
+	list := fs.Bool("list", false, "list the policies and exit")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *list {
+		for _, p := range policy.Pack {
+			fmt.Printf("%-26s %-10s %s\n", p.Name, p.Level, p.Description)
+		}
+		return nil
+	}
+
+	pack, cleanup, err := pf.pack(sf.dir, sf.stack)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	stack, err := sf.open(ctx)
+	if err != nil {
+		return err
+	}
+	return checkPolicies(ctx, stack, pack, os.Stdout)
+}
+
+// checkPolicies runs `pulumi preview` on stack with the policy pack and
+// prints the violations it reports. Only mandatory violations fail the
+// check.
+func checkPolicies(ctx context.Context, stack auto.Stack, pack policyPack, w io.Writer) error {
+	ch := make(chan events.EngineEvent)
+	done := make(chan [2]int)
+	go func() {
+		var total, mandatory int
+		for ev := range ch {
+			p := ev.PolicyEvent
+			if p == nil {
+				continue
+			}
+			total++
+			if p.EnforcementLevel == "mandatory" {
+				mandatory++
+			}
+			fmt.Fprintf(w, "[%s] %s: %s\n", p.EnforcementLevel, p.PolicyName, strings.TrimSpace(p.Message))
+			if p.ResourceURN != "" {
+				fmt.Fprintf(w, "    %s\n", p.ResourceURN)
+			}
+		}
+		done <- [2]int{total, mandatory}
+	}()
+
+	_, err := stack.Preview(ctx, optpreview.EventStreams(ch), pack.previewOption())
+	// Once pulumi has run, the stream is closed before Preview returns,
+	// whether or not the preview failed. Any other error comes before the
+	// event log is set up, and the stream is never used.
+	if err != nil && !strings.Contains(err.Error(), "failed to run preview") {
+		return fmt.Errorf("previewing stack %s: %w", stack.Name(), err)
+	}
+	counts := <-done
+	fmt.Fprintf(w, "Policies: %d violations (%d mandatory)\n", counts[0], counts[1])
+	switch {
+	case counts[1] > 0:
+		return errPolicyViolations
+	case err != nil:
+		return fmt.Errorf("previewing stack %s: %w", stack.Name(), err)
+	}
+	return nil
+}
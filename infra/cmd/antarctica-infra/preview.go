@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/dns"
+	"github.com/nerdsrun/antarctica/infra/pkg/hostkeys"
+	"github.com/nerdsrun/antarctica/infra/pkg/outputs"
+	"github.com/nerdsrun/antarctica/infra/pkg/preview"
+	"github.com/nerdsrun/antarctica/infra/pkg/secrets"
+	"github.com/nerdsrun/antarctica/infra/pkg/stackconfig"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+)
+
+// expiresAtKey is the config key holding a preview's teardown deadline.
+const expiresAtKey = stackconfig.Namespace + ":preview_expires_at"
+
+// runPreviewEnv dispatches the preview-env subcommands.
+func runPreviewEnv(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: antarctica-infra preview-env <up|down|list|reap> [flags]")
+	}
+	switch args[0] {
+	case "up":
+		return previewUp(ctx, args[1:])
+	case "down":
+		return previewDown(ctx, args[1:])
+	case "list":
+		return previewList(ctx, args[1:])
+	case "reap":
+		return previewReap(ctx, args[1:])
+	}
+	return fmt.Errorf("unknown preview-env subcommand %q (want up, down, list or reap)", args[0])
+}
+
+// previewFlags are the flags shared by preview-env up and down.
+type previewFlags struct {
+	stackFlags
+	number   int
+	vmIDBase int
+}
+
+// register adds the shared flags; -stack names the stack previews are
+// seeded from.
+func (f *previewFlags) register(fs *flag.FlagSet) {
+	f.stackFlags.register(fs)
+	fs.IntVar(&f.number, "pr", 0, "pull request number the environment belongs to (required)")
+	fs.IntVar(&f.vmIDBase, "vm-id-base", preview.DefaultVMIDBase, "added to -pr to derive the VM ID")
+}
+
+// env validates the flags and returns the environment they describe.
+func (f *previewFlags) env() (preview.Env, error) {
+	if f.number <= 0 {
+		return preview.Env{}, errors.New("-pr is required and must be positive")
+	}
+	env := preview.Env{Number: f.number, VMIDBase: f.vmIDBase}
+	if env.VMID() >= preview.TemplateVMIDs {
+		return preview.Env{}, fmt.Errorf("VM ID %d (-vm-id-base %d + -pr %d) reaches the template range (%d+)",
+			env.VMID(), f.vmIDBase, f.number, preview.TemplateVMIDs)
+	}
+	return env, nil
+}
+
+// previewUp creates or updates a preview stack from the shared stack's
+// config, runs `pulumi up` on it and then the playbook against the preview
+// VM, so role changes are tried on a full stack. Arguments after the flags
+// (or after "--") are passed to ansible-playbook.
+func previewUp(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("preview-env up", flag.ContinueOnError)
+	var pf previewFlags
+	pf.register(fs)
+	ttl := fs.Duration("ttl", 72*time.Hour, "lifetime after which preview-env reap tears the environment down")
+	playbook := fs.String("playbook", "site.yml", "playbook under ansible/playbooks to run against the preview VM; empty skips Ansible")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	env, err := pf.env()
+	if err != nil {
+		return err
+	}
+
+	base, err := pf.open(ctx)
+	if err != nil {
+		return err
+	}
+	baseConfig, err := base.GetAllConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("reading config of stack %s: %w", pf.stack, err)
+	}
+	baseCfg, err := loadConfig(ctx, base)
+	if err != nil {
+		return err
+	}
+
+	stack, err := auto.UpsertStackLocalSource(ctx, env.StackName(), pf.dir)
+	if err != nil {
+		return fmt.Errorf("creating stack %s: %w", env.StackName(), err)
+	}
+
+	// Seed from the shared stack's provider config and the inherited
+	// antarctica:* keys, then apply the preview overrides.
+	overrides := env.Overrides(baseCfg.VM.Hostname, time.Now().Add(*ttl))
+	cfg := auto.ConfigMap{}
+	for k, v := range baseConfig {
+		ns, key, _ := strings.Cut(k, ":")
+		if ns != stackconfig.Namespace || preview.Inherits(key) {
+			cfg[k] = v
+		}
+	}
+	for k, v := range overrides {
+		cfg[stackconfig.Namespace+":"+k] = auto.ConfigValue{Value: v}
+	}
+	if err := stack.SetAllConfig(ctx, cfg); err != nil {
+		return fmt.Errorf("configuring stack %s: %w", env.StackName(), err)
+	}
+
+	// A re-run must also drop keys the preview no longer inherits.
+	current, err := stack.GetAllConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("reading config of stack %s: %w", env.StackName(), err)
+	}
+	var removed []string
+	for k := range current {
+		if _, ok := cfg[k]; !ok && strings.HasPrefix(k, stackconfig.Namespace+":") {
+			removed = append(removed, k)
+		}
+	}
+	if len(removed) > 0 {
+		if err := stack.RemoveAllConfig(ctx, removed); err != nil {
+			return fmt.Errorf("configuring stack %s: %w", env.StackName(), err)
+		}
+	}
+
+	var noneDisabled policyFlags
+	pack, cleanup, err := noneDisabled.pack(pf.dir, env.StackName())
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	fmt.Printf("Deploying %s (VM %d, %s)\n", env.StackName(), env.VMID(), env.Hostname(baseCfg.VM.Hostname))
+	res, err := stack.Up(ctx, optup.ProgressStreams(os.Stdout), pack.upOption())
+	if err != nil {
+		return fmt.Errorf("deploying %s: %w", env.StackName(), err)
+	}
+	if err := storeACMEKey(res.Outputs); err != nil {
+		return err
+	}
+
+	if *playbook != "" {
+		if err := configurePreview(ctx, filepath.Join(pf.dir, "..", "ansible"), *playbook, res.Outputs, fs.Args()); err != nil {
+			return fmt.Errorf("configuring %s: %w", env.StackName(), err)
+		}
+	}
+
+	if baseCfg.DNSDomain != "" {
+		domain := dns.ServiceDomain(baseCfg.DNSDomain, env.Name())
+		if records, err := baseCfg.Records(pf.dir); err == nil {
+			fmt.Println()
+			for _, rec := range records {
+				fmt.Printf("Service: https://%s.%s\n", rec.Subdomain, domain)
+			}
+		}
+	}
+	fmt.Printf("Expires: %s (run `antarctica-infra preview-env reap` to enforce)\n",
+		cfg[expiresAtKey].Value)
+	return nil
+}
+
+// configurePreview runs playbook against a preview VM. The inventory reads
+// the preview's outputs from a private directory instead of the shared
+// pulumi_output.json, and only the Pulumi inventory is used, so the
+// static hosts.yml (the shared VM) is never targeted.
+func configurePreview(ctx context.Context, ansibleDir, playbook string, raw auto.OutputMap, args []string) error {
+	out, err := outputs.Decode(raw[outputs.Key].Value)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "antarctica-preview-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := writeInventoryOutputs(dir, raw, out); err != nil {
+		return err
+	}
+
+	env := []string{"ANTARCTICA_PULUMI_OUTPUT=" + filepath.Join(dir, "pulumi_output.json")}
+	_, err = runPlaybook(ctx, ansibleDir, playbook, "inventory/pulumi_inventory.py", env, args)
+	return err
+}
+
+// previewDown destroys a preview environment and removes its stack.
+func previewDown(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("preview-env down", flag.ContinueOnError)
+	var pf previewFlags
+	pf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	env, err := pf.env()
+	if err != nil {
+		return err
+	}
+	return destroyPreview(ctx, env.StackName(), pf.dir)
+}
+
+// previewList prints every preview stack with its expiry.
+func previewList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("preview-env list", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	stacks, err := listPreviews(ctx, sf.dir)
+	if err != nil {
+		return err
+	}
+	if len(stacks) == 0 {
+		fmt.Println("No preview environments.")
+		return nil
+	}
+
+	now := time.Now()
+	fmt.Printf("%-20s %-26s %s\n", "STACK", "EXPIRES", "STATUS")
+	for _, s := range stacks {
+		status := "active"
+		if preview.Expired(s.expiresAt, now) {
+			status = "expired"
+		}
+		fmt.Printf("%-20s %-26s %s\n", s.name, orDefault(s.expiresAt, "-"), status)
+	}
+	return nil
+}
+
+// previewReap destroys every preview environment past its TTL. Meant to run
+// on a schedule (e.g. a nightly Woodpecker cron).
+func previewReap(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("preview-env reap", flag.ContinueOnError)
+	var sf stackFlags
+	sf.register(fs)
+	dryRun := fs.Bool("dry-run", false, "only list the environments that would be destroyed")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	stacks, err := listPreviews(ctx, sf.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var errs []error
+	for _, s := range stacks {
+		if !preview.Expired(s.expiresAt, now) {
+			continue
+		}
+		fmt.Printf("%s expired at %s\n", s.name, s.expiresAt)
+		if *dryRun {
+			continue
+		}
+		if err := destroyPreview(ctx, s.name, sf.dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// previewStack is a preview stack and its teardown deadline.
+type previewStack struct {
+	name      string
+	expiresAt string
+}
+
+// listPreviews returns every stack of the project that is a preview.
+func listPreviews(ctx context.Context, dir string) ([]previewStack, error) {
+	ws, err := auto.NewLocalWorkspace(ctx, auto.WorkDir(dir))
+	if err != nil {
+		return nil, fmt.Errorf("opening workspace %s: %w", dir, err)
+	}
+	summaries, err := ws.ListStacks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing stacks: %w", err)
+	}
+
+	var result []previewStack
+	for _, s := range summaries {
+		if _, ok := preview.ParseStackName(s.Name); !ok {
+			continue
+		}
+		cfg, err := ws.GetAllConfig(ctx, s.Name)
+		if err != nil {
+			return nil, fmt.Errorf("reading config of %s: %w", s.Name, err)
+		}
+		result = append(result, previewStack{name: s.Name, expiresAt: cfg[expiresAtKey].Value})
+	}
+	return result, nil
+}
+
+// destroyPreview destroys a preview stack's resources and removes the stack.
+// Refuses anything that is not a preview stack.
+func destroyPreview(ctx context.Context, name, dir string) error {
+	if _, ok := preview.ParseStackName(name); !ok {
+		return fmt.Errorf("refusing to destroy %s: not a preview stack", name)
+	}
+	stack, err := auto.SelectStackLocalSource(ctx, name, dir)
+	if err != nil {
+		return fmt.Errorf("selecting stack %s: %w", name, err)
+	}
+
+	// Read the config first: it is gone once the stack is removed.
+	cfg, err := loadConfig(ctx, stack)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Destroying %s\n", name)
+	if _, err := stack.Destroy(ctx, optdestroy.ProgressStreams(os.Stdout)); err != nil {
+		return fmt.Errorf("destroying %s: %w", name, err)
+	}
+	if err := stack.Workspace().RemoveStack(ctx, name); err != nil {
+		return fmt.Errorf("removing stack %s: %w", name, err)
+	}
+
+	// The preview's pinned host keys die with its VM.
+	if cfg.PinHostKeys && secrets.CLIAvailable() {
+		if err := secrets.DeleteItem(cfg.HostKeyVault, hostkeys.ItemTitle(cfg.VM.Hostname)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/outputs"
+)
+
+// runSchema prints or writes the JSON Schema of the antarctica stack
+// output, or checks that the committed copy is current. A schema that
+// changed while outputs.SchemaVersion did not is reported as such, so a
+// renamed key cannot slip through as a silent regeneration.
+func runSchema(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	outFile := fs.String("out", "", "write the schema to this file instead of stdout")
+	checkFile := fs.String("check", "", "fail if this committed schema differs from the generated one")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	generated, err := outputs.SchemaJSON()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *checkFile != "":
+		committed, err := os.ReadFile(*checkFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if bytes.Equal(committed, generated) {
+			fmt.Printf("%s is up to date (schema_version %d)\n", *checkFile, outputs.SchemaVersion)
+			return nil
+		}
+		if outputs.SchemaVersionOf(committed) == outputs.SchemaVersion {
+			return fmt.Errorf("outputs.Outputs changed but outputs.SchemaVersion is still %d: "+
+				"bump it and run `go generate ./pkg/outputs`", outputs.SchemaVersion)
+		}
+		return fmt.Errorf("%s is out of date: run `go generate ./pkg/outputs`", *checkFile)
+	case *outFile != "":
+		return os.WriteFile(*outFile, generated, 0o644)
+	default:
+		_, err := os.Stdout.Write(generated)
+		return err
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/secrets"
+)
+
+// errSecretsMismatch makes the command exit non-zero once findings are printed.
+var errSecretsMismatch = errors.New("op:// references and the secrets manifest disagree")
+
+// secretsScanDirs are the Ansible directories, relative to -ansible, whose
+// op:// references are resolved at deploy time.
+var secretsScanDirs = []string{"inventory", "roles", "playbooks"}
+
+// secretsSkipDirs hold mock op:// responses for Molecule and integration
+// tests rather than real references.
+var secretsSkipDirs = []string{"molecule", "tests"}
+
+// runSecretsCheck resolves every op:// reference in the Ansible tree against
+// secrets.Manifest and reports undeclared items and fields, and manifest
+// fields nothing references.
+func runSecretsCheck(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("secrets-check", flag.ContinueOnError)
+	ansibleDir := fs.String("ansible", filepath.Join(defaultProgramDir(), "..", "ansible"), "Ansible directory to scan")
+	allowUnused := fs.Bool("allow-unused", false, "do not fail on manifest fields nothing references")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var refs []secrets.Reference
+	for _, dir := range secretsScanDirs {
+		root := filepath.Join(*ansibleDir, dir)
+		if _, err := os.Stat(root); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		found, err := secrets.ScanReferences(root, secretsSkipDirs)
+		if err != nil {
+			return err
+		}
+		for i := range found {
+			found[i].File = filepath.Join(dir, found[i].File)
+		}
+		refs = append(refs, found...)
+	}
+
+	report := secrets.CrossCheck(refs, secrets.Manifest())
+	fmt.Printf("Checked %d op:// references against %d manifest items\n", len(refs), len(secrets.Manifest()))
+	printFindings("Undeclared items", report.UndeclaredItems)
+	printFindings("Undeclared fields", report.UndeclaredFields)
+	printFindings("Unused manifest fields", report.UnusedFields)
+
+	if len(report.UndeclaredItems)+len(report.UndeclaredFields) > 0 {
+		return errSecretsMismatch
+	}
+	if len(report.UnusedFields) > 0 && !*allowUnused {
+		return errSecretsMismatch
+	}
+	return nil
+}
+
+// printFindings prints one section of the report, listing where each
+// offending reference appears.
+func printFindings(title string, findings []secrets.Finding) {
+	if len(findings) == 0 {
+		return
+	}
+	fmt.Printf("\n%s:\n", title)
+	for _, f := range findings {
+		fmt.Printf("  %s\n", f.Message)
+		for _, ref := range f.References {
+			fmt.Printf("    %s:%d\n", ref.File, ref.Line)
+		}
+	}
+}
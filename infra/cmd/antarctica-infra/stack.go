@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/cluster"
+	"github.com/nerdsrun/antarctica/infra/pkg/foundation"
+	"github.com/nerdsrun/antarctica/infra/pkg/outputs"
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi"
+	"github.com/nerdsrun/antarctica/infra/pkg/remote"
+	"github.com/nerdsrun/antarctica/infra/pkg/stackconfig"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// stackFlags are the flags shared by every command that opens a stack.
+type stackFlags struct {
+	stack string
+	dir   string
+}
+
+// register adds -stack and -dir to fs.
+func (f *stackFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.stack, "stack", "dev", "Pulumi stack name")
+	fs.StringVar(&f.dir, "dir", defaultProgramDir(), "directory containing Pulumi.yaml")
+}
+
+// open selects the stack through the automation API.
+func (f *stackFlags) open(ctx context.Context) (auto.Stack, error) {
+	stack, err := auto.SelectStackLocalSource(ctx, f.stack, f.dir)
+	if err != nil {
+		return auto.Stack{}, fmt.Errorf("selecting stack %s in %s: %w", f.stack, f.dir, err)
+	}
+	return stack, nil
+}
+
+// vmFlags are the flags shared by every command that SSHes into the VM.
+type vmFlags struct {
+	host    string
+	keyFile string
+}
+
+// register adds -host and -key to fs.
+func (f *vmFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.host, "host", os.Getenv("ANTARCTICA_HOST"), "override the VM address from the stack outputs")
+	fs.StringVar(&f.keyFile, "key", "", "SSH private key (default $ANTARCTICA_SSH_KEY or "+remote.DefaultKeyFile+")")
+}
+
+// address returns -host, or the VM address from the stack outputs.
+func (f *vmFlags) address(out *outputs.Outputs) string {
+	if f.host != "" || out == nil {
+		return f.host
+	}
+	return out.VMIP
+}
+
+// dial connects to the VM described by the stack outputs, checking its
+// host key against the pinned known_hosts when present. out may be nil
+// for a stack without outputs, in which case -host is required.
+func (f *vmFlags) dial(ctx context.Context, out *outputs.Outputs) (*remote.Client, error) {
+	host := f.address(out)
+	if host == "" {
+		return nil, errors.New("stack has no VM address; pass -host")
+	}
+	cfg := remote.Config{Host: host, Port: 22, User: "antarctica", KeyFile: f.keyFile}
+	if out != nil {
+		cfg.Port = orDefault(out.SSHPort, 22)
+		cfg.User = orDefault(out.SSHUser, "antarctica")
+		cfg.KnownHosts = out.SSHKnownHosts
+	}
+	return remote.Dial(ctx, cfg)
+}
+
+// loadOutputs reads and decodes the antarctica output of stack.
+func loadOutputs(ctx context.Context, stack auto.Stack) (*outputs.Outputs, error) {
+	raw, err := stack.Outputs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading outputs of stack %s: %w", stack.Name(), err)
+	}
+	return outputs.Decode(raw[outputs.Key].Value)
+}
+
+// stackConfig is the stack config as both typed settings and raw values,
+// for keys outside the antarctica namespace (e.g. gcp:project).
+type stackConfig struct {
+	*stackconfig.Stack
+	raw auto.ConfigMap
+}
+
+// get returns a raw config value by fully qualified key ("gcp:project").
+func (c stackConfig) get(key string) string {
+	return c.raw[key].Value
+}
+
+// proxmoxClient returns an API client for the stack's cluster. Clusters
+// listed in antarctica:proxmox_clusters bring their own endpoint and token;
+// the default one is read from proxmoxve:* config and PROXMOX_VE_*.
+func (c stackConfig) proxmoxClient() (*pveapi.Client, error) {
+	cl, ok := c.Clusters[c.VM.Cluster]
+	if !ok {
+		var err error
+		cl, err = cluster.FromProviderConfig(func(key string) string {
+			return c.get("proxmoxve:" + key)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	client, err := cl.Client()
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", c.VM.Cluster, err)
+	}
+	return client, nil
+}
+
+// loadConfig reads and parses the stack config.
+func loadConfig(ctx context.Context, stack auto.Stack) (stackConfig, error) {
+	raw, err := stack.GetAllConfig(ctx)
+	if err != nil {
+		return stackConfig{}, fmt.Errorf("reading config of stack %s: %w", stack.Name(), err)
+	}
+
+	get := stackconfig.Getter(func(key string) string {
+		return raw[stackconfig.Namespace+":"+key].Value
+	})
+	var inherited map[string]string
+	if name := get("foundation_stack"); name != "" {
+		base, err := loadFoundation(ctx, stack.Workspace().WorkDir(), name)
+		if err != nil {
+			return stackConfig{}, err
+		}
+		inherited = base.ConfigDefaults()
+	}
+
+	parsed, err := stackconfig.Load(get, inherited)
+	if err != nil {
+		return stackConfig{}, err
+	}
+	return stackConfig{Stack: parsed, raw: raw}, nil
+}
+
+// loadFoundation reads the outputs of the foundation stack name, the way
+// the program's StackReference does. The foundation program lives in the
+// foundation/ directory of dir.
+func loadFoundation(ctx context.Context, dir, name string) (*foundation.Outputs, error) {
+	stack, err := auto.SelectStackLocalSource(ctx, name, filepath.Join(dir, "foundation"))
+	if err != nil {
+		return nil, fmt.Errorf("selecting foundation stack %s: %w", name, err)
+	}
+	raw, err := stack.Outputs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading outputs of foundation stack %s: %w", name, err)
+	}
+	out, err := foundation.Decode(raw[foundation.Key].Value)
+	if err != nil {
+		return nil, fmt.Errorf("foundation stack %s: %w", name, err)
+	}
+	return out, nil
+}
+
+// defaultProgramDir finds the infra/ directory: under MISE_PROJECT_ROOT
+// when run as a mise task, otherwise relative to the working directory.
+func defaultProgramDir() string {
+	if root := os.Getenv("MISE_PROJECT_ROOT"); root != "" {
+		return filepath.Join(root, "infra")
+	}
+	if _, err := os.Stat("Pulumi.yaml"); err == nil {
+		return "."
+	}
+	return "infra"
+}
+
+// parseFlags parses args into fs, printing usage on -h.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Antarctica foundation entrypoint.
+//
+// This program provisions what every Antarctica environment shares: the
+// Cloud DNS managed zone, the cluster-wide Proxmox firewall security groups
+// and the cloud-init template VMs are cloned from. Antarctica stacks read
+// them through a StackReference (see package foundation). With
+// foundation:create_dns_zone=false an existing zone is looked up instead
+// of created, and with foundation:create_template=false an existing
+// template is used as configured.
+//
+// Stack outputs:
+//
+//	foundation - Shared zone, network and template values, shaped by
+//	             foundation.Outputs and versioned by its schema_version;
+//	             includes the zone's name servers and DNSSEC DS records
+package main
+
+import (
+	"github.com/nerdsrun/antarctica/infra/pkg/cluster"
+	"github.com/nerdsrun/antarctica/infra/pkg/dns"
+	"github.com/nerdsrun/antarctica/infra/pkg/foundation"
+	"github.com/nerdsrun/antarctica/infra/pkg/network"
+	"github.com/nerdsrun/antarctica/infra/pkg/vm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+func main() {
+	pulumi.Run(func(ctx *pulumi.Context) error {
+		cfg, err := foundation.LoadConfig(config.New(ctx, foundation.Namespace).Get)
+		if err != nil {
+			return err
+		}
+
+		// --- Cloud DNS managed zone ---
+		zone, err := dns.EnsureZone(ctx, cfg.Zone)
+		if err != nil {
+			return err
+		}
+
+		// --- Proxmox provider ---
+		providers, err := cluster.NewProviders(ctx, nil)
+		if err != nil {
+			return err
+		}
+		proxmox := pulumi.Provider(providers[cluster.DefaultName])
+
+		// --- Proxmox firewall security groups ---
+		groups, err := network.CreateSecurityGroups(ctx, proxmox)
+		if err != nil {
+			return err
+		}
+
+		// --- Cloud-init template ---
+		if cfg.CreateTemplate {
+			if _, err := vm.CreateTemplate(ctx, cfg.Template, proxmox); err != nil {
+				return err
+			}
+		}
+
+		ctx.Export(foundation.Key, pulumi.All(zone.NameServers, zone.DSRecords).ApplyT(
+			func(args []interface{}) foundation.Outputs {
+				return foundation.Outputs{
+					SchemaVersion:  foundation.SchemaVersion,
+					DNSZone:        zone.Name,
+					DNSDomain:      zone.Domain,
+					DNSVisibility:  zone.Visibility,
+					DNSNameServers: args[0].([]string),
+					DNSDSRecords:   args[1].([]string),
+					NetworkBridge:  cfg.NetworkBridge,
+					Gateway:        cfg.Gateway,
+					Nameserver:     cfg.Nameserver,
+					TemplateNode:   cfg.Template.Node,
+					TemplateVMID:   cfg.Template.VMID,
+					TemplateName:   cfg.Template.Name,
+					SecurityGroups: groups,
+				}
+			}))
+		return nil
+	})
+}
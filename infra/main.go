@@ -1,26 +1,32 @@
 // Antarctica Pulumi entrypoint.
 //
-// This program provisions a single Proxmox VM and exports connection details
-// for Ansible to consume. It does NOT install software or configure services
+// This program provisions a single Proxmox VM (plus an optional cold
+// standby, see package dr) and exports connection details for Ansible to
+// consume. It does NOT install software or configure services
 // on the VM -- that is Ansible's responsibility.
 //
-// Stack outputs consumed by Ansible:
+// Stack outputs:
 //
-//	vm_ip          - IPv4 address of the VM
-//	vm_hostname    - Hostname
-//	ssh_user       - Cloud-init user
-//	ssh_port       - SSH port (always 22)
-//	data_disk_gb   - Size of the /data disk
-//	data_paths     - Expected /data subdirectories
-//	firewall_ports - TCP ports to open
+//	antarctica       - Everything Ansible consumes, shaped by outputs.Outputs
+//	                   and versioned by its schema_version field
+//	secrets_manifest - 1Password items the deployment expects (secret)
 package main
 
 import (
-	"strconv"
+	"fmt"
 
+	"github.com/nerdsrun/antarctica/infra/pkg/acme"
+	"github.com/nerdsrun/antarctica/infra/pkg/cluster"
 	"github.com/nerdsrun/antarctica/infra/pkg/dns"
+	"github.com/nerdsrun/antarctica/infra/pkg/dr"
+	"github.com/nerdsrun/antarctica/infra/pkg/foundation"
+	"github.com/nerdsrun/antarctica/infra/pkg/hostkeys"
+	"github.com/nerdsrun/antarctica/infra/pkg/mail"
 	"github.com/nerdsrun/antarctica/infra/pkg/network"
+	"github.com/nerdsrun/antarctica/infra/pkg/outputs"
+	"github.com/nerdsrun/antarctica/infra/pkg/pki"
 	"github.com/nerdsrun/antarctica/infra/pkg/secrets"
+	"github.com/nerdsrun/antarctica/infra/pkg/stackconfig"
 	"github.com/nerdsrun/antarctica/infra/pkg/storage"
 	"github.com/nerdsrun/antarctica/infra/pkg/vm"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
@@ -29,101 +35,242 @@ import (
 
 func main() {
 	pulumi.Run(func(ctx *pulumi.Context) error {
-		cfg := config.New(ctx, "antarctica")
+		cfg := config.New(ctx, stackconfig.Namespace)
 
-		// Read all config values with sensible defaults.
-		node := cfg.Require("proxmox_node")
-		hostname := cfg.Get("hostname")
-		if hostname == "" {
-			hostname = "antarctica"
+		// --- Inherit zone, network and template from the foundation stack ---
+		var base *foundation.Outputs
+		var inherited map[string]string
+		if name := cfg.Get("foundation_stack"); name != "" {
+			var err error
+			if base, err = foundation.Read(ctx, name); err != nil {
+				return err
+			}
+			inherited = base.ConfigDefaults()
 		}
 
-		vmID := intConfig(cfg, "vm_id", 200)
-		templateVMID := intConfig(cfg, "template_vm_id", 9000)
-		cpuCores := intConfig(cfg, "cpu_cores", 4)
-		memoryMB := intConfig(cfg, "memory_mb", 8192)
-		bootDiskGB := intConfig(cfg, "boot_disk_gb", 50)
-		dataDiskGB := intConfig(cfg, "data_disk_gb", 100)
-
-		cloudInitTemplate := cfg.Get("cloud_init_template")
-		if cloudInitTemplate == "" {
-			cloudInitTemplate = "debian-12-cloudinit"
+		// Read all config values with sensible defaults.
+		stack, err := stackconfig.Load(cfg.Get, inherited)
+		if err != nil {
+			return err
 		}
-
-		storagePool := cfg.Get("storage_pool")
-		if storagePool == "" {
-			storagePool = "local-lvm"
+		vmCfg := stack.VM
+		records, err := stack.Records(".")
+		if err != nil {
+			return err
 		}
 
-		networkBridge := cfg.Get("network_bridge")
-		if networkBridge == "" {
-			networkBridge = "vmbr0"
+		// --- Refuse disk shrinks; record filesystem growth for Ansible ---
+		clusters, err := cluster.WithDefault(ctx, stack.Clusters)
+		if err != nil {
+			return err
+		}
+		client, err := clusters[vmCfg.Cluster].Client()
+		if err != nil {
+			return err
+		}
+		disks := storage.Layout(vmCfg.BootDiskGB, vmCfg.DataDiskGB)
+		currentSizes, err := storage.CurrentSizes(ctx.Context(), client, vmCfg.Node, vmCfg.VMID, disks)
+		if err != nil {
+			return err
+		}
+		pendingGrow, err := storage.CheckResize(ctx, currentSizes, disks)
+		if err != nil {
+			return err
 		}
 
-		ipAddress := cfg.Get("ip_address")
-		gateway := cfg.Get("gateway")
-		nameserver := cfg.Get("nameserver")
-		sshUser := cfg.Get("ssh_user")
-		if sshUser == "" {
-			sshUser = "antarctica"
+		// --- Pin SSH host keys through cloud-init ---
+		var hostKeys *hostkeys.Set
+		if stack.PinHostKeys {
+			hostKeys, err = hostkeys.Ensure(ctx, stack.HostKeyVault, vmCfg.Hostname)
+			if err != nil {
+				return err
+			}
+			vmCfg.VendorData = hostKeys.CloudConfig()
 		}
 
-		sshPort := intConfig(cfg, "ssh_port", 22)
-		sshPublicKeys := cfg.Get("ssh_public_keys")
+		// --- Proxmox provider of the VM's cluster ---
+		providers, err := cluster.NewProviders(ctx, stack.Clusters)
+		if err != nil {
+			return err
+		}
+		provider, err := providers.Get(vmCfg.Cluster)
+		if err != nil {
+			return err
+		}
+		proxmox := pulumi.Provider(provider)
 
 		// --- Provision the VM ---
-		vmResult, err := vm.Provision(ctx, vm.Config{
-			Node:              node,
-			VMID:              vmID,
-			TemplateVMID:      templateVMID,
-			Hostname:          hostname,
-			CPUCores:          cpuCores,
-			MemoryMB:          memoryMB,
-			BootDiskGB:        bootDiskGB,
-			DataDiskGB:        dataDiskGB,
-			CloudInitTemplate: cloudInitTemplate,
-			StoragePool:       storagePool,
-			NetworkBridge:     networkBridge,
-			IPAddress:         ipAddress,
-			Gateway:           gateway,
-			Nameserver:        nameserver,
-			SSHPublicKeys:     sshPublicKeys,
-			SSHUser:           sshUser,
-		})
+		vmResult, err := vm.Provision(ctx, vmCfg, proxmox)
 		if err != nil {
 			return err
 		}
+		if stack.AttachSecurityGroups {
+			if err := network.AttachSecurityGroups(ctx, vmCfg.Hostname+"-firewall", vmCfg.Node, vmCfg.VMID,
+				base.SecurityGroups, proxmox, pulumi.DependsOn([]pulumi.Resource{vmResult.VM})); err != nil {
+				return err
+			}
+		}
 
-		// --- Export connection details for Ansible ---
-		ctx.Export("vm_ip", vmResult.IPAddress)
-		ctx.Export("vm_hostname", pulumi.String(hostname))
-		ctx.Export("ssh_user", pulumi.String(sshUser))
-		ctx.Export("ssh_port", pulumi.Int(sshPort))
+		// --- Cold standby on a second node, fed by storage replication ---
+		serviceIP := vmResult.IPAddress
+		drOut := outputs.DR{FailoverSteps: []string{}}
+		replicationJob := pulumi.String("").ToStringOutput()
+		if stack.DR.Enabled() {
+			standbyCfg := dr.Standby(vmCfg, stack.DR)
+			standbyProvider, err := providers.Get(standbyCfg.Cluster)
+			if err != nil {
+				return err
+			}
+			standby, err := vm.Provision(ctx, standbyCfg, pulumi.Provider(standbyProvider))
+			if err != nil {
+				return err
+			}
+			job := dr.Job(vmCfg, stack.DR)
+			switch {
+			case stack.DR.Active:
+				// The primary is gone; replicating from it would fail.
+				ctx.Log.Warn("dr_active is set: service records point at the standby, replication is not managed", nil)
+				replicationJob = pulumi.String(job.ID).ToStringOutput()
+				serviceIP = standby.IPAddress
+			case stack.DR.CrossCluster(vmCfg):
+				// Proxmox cannot replicate across clusters; the standby
+				// is restored from backups instead (see package dr).
+				ctx.Log.Warn(fmt.Sprintf("the standby is on cluster %s, not %s: its data disk is not replicated",
+					standbyCfg.Cluster, vmCfg.Cluster), nil)
+			default:
+				replicationJob = dr.Replicate(ctx, client, vmResult, job)
+			}
+			drOut = outputs.DR{
+				StandbyHostname: standbyCfg.Hostname,
+				StandbyNode:     standbyCfg.Node,
+				StandbyVMID:     standbyCfg.VMID,
+				StandbyIP:       vm.StripCIDR(standbyCfg.IPAddress),
+				Active:          stack.DR.Active,
+				FailoverSteps:   dr.Steps(ctx.Stack(), vmCfg, standbyCfg),
+			}
+		}
+
+		// --- GCP credentials for Caddy's ACME DNS-01 challenges ---
+		acmeReference := pulumi.String("").ToStringOutput()
+		acmeProject := pulumi.String("").ToStringOutput()
+		if stack.ACMEDNSZone != "" {
+			creds, err := acme.Provision(ctx, acme.Config{
+				Zone:     stack.ACMEDNSZone,
+				Hostname: vmCfg.Hostname,
+				Vault:    stack.ACMEVault,
+			})
+			if err != nil {
+				return err
+			}
+			acmeReference, acmeProject = creds.Reference, creds.Project
+			ctx.Export(acme.KeyOutput, creds.Key)
+		}
+
+		// --- Private CA for offline copies without ACME ---
+		pkiCACert := pulumi.String("").ToStringOutput()
+		pkiItem := pulumi.String("").ToStringOutput()
+		if stack.PKI {
+			bundle, err := pki.Provision(ctx, pki.Config{
+				Domain:   dns.ServiceDomain(stack.DNSDomain, stack.DNSPrefix),
+				Records:  records,
+				Hostname: vmCfg.Hostname,
+				Vault:    stack.PKIVault,
+			})
+			if err != nil {
+				return err
+			}
+			pkiCACert, pkiItem = bundle.CACertificate, bundle.Item
+		}
 
-		// --- Export network details ---
-		network.Export(ctx, network.Config{
-			IPAddress: vmResult.IPAddress,
-			Hostname:  hostname,
-			Bridge:    networkBridge,
-			Gateway:   gateway,
-		})
+		// --- SPF, DKIM and DMARC records for Forgejo's notification mail ---
+		var mailOut outputs.Mail
+		if stack.Mail.Enabled() {
+			settings, err := mail.Provision(ctx, stack.Mail)
+			if err != nil {
+				return err
+			}
+			mailOut = outputs.Mail{
+				From:           settings.From,
+				Relay:          settings.Relay,
+				DKIMDomain:     settings.Domain,
+				DKIMSelector:   settings.Selector,
+				DKIMPrivateKey: settings.KeyReference,
+			}
+		}
 
-		// --- Export storage layout ---
-		storage.ExportDataLayout(ctx, dataDiskGB)
+		// --- Export everything Ansible consumes as one versioned object ---
+		serviceDomain := ""
+		if stack.DNSDomain != "" {
+			serviceDomain = dns.ServiceDomain(stack.DNSDomain, stack.DNSPrefix)
+		}
+		out := outputs.Outputs{
+			SchemaVersion:  outputs.SchemaVersion,
+			VMHostname:     vmCfg.Hostname,
+			ServiceDomain:  serviceDomain,
+			SSHUser:        vmCfg.SSHUser,
+			SSHPort:        stack.SSHPort,
+			SSHHostKeys:    []string{},
+			NetworkBridge:  vmCfg.NetworkBridge,
+			NetworkGateway: vmCfg.Gateway,
+			FirewallPorts:  network.FirewallPorts,
+			DataDiskGB:     vmCfg.DataDiskGB,
+			DataPaths:      storage.DataPaths,
+			DiskSizes:      storage.Sizes(disks),
+			PendingFSGrow:  pendingGrow,
+			DR:             drOut,
+			Mail:           mailOut,
+		}
+		if hostKeys != nil {
+			out.SSHHostKeys = hostKeys.PublicKeys()
+		}
+		ctx.Export(outputs.Key, pulumi.All(serviceIP, acmeReference, acmeProject, pkiCACert, pkiItem, replicationJob).ApplyT(func(args []interface{}) outputs.Outputs {
+			ip := args[0].(string)
+			out.VMIP = ip
+			out.ACMEDNSCredentials = args[1].(string)
+			out.ACMEDNSProject = args[2].(string)
+			out.PKICACertificate = args[3].(string)
+			out.PKIItem = args[4].(string)
+			out.DR.ReplicationJob = args[5].(string)
+			if hostKeys != nil {
+				out.SSHKnownHosts = hostKeys.KnownHosts([]string{ip, vmCfg.Hostname}, stack.SSHPort)
+			}
+			return out
+		}))
 
 		// --- Create DNS records in GCP Cloud DNS ---
-		gcpDNSZone := cfg.Get("gcp_dns_zone")
-		dnsDomain := cfg.Get("dns_domain")
-		if gcpDNSZone != "" && dnsDomain != "" {
+		if stack.GCPDNSZone != "" && stack.DNSDomain != "" {
 			if err := dns.CreateRecords(ctx, dns.Config{
-				ManagedZone: gcpDNSZone,
-				Domain:      dnsDomain,
-				IPAddress:   vmResult.IPAddress,
+				ManagedZone: stack.GCPDNSZone,
+				Domain:      stack.DNSDomain,
+				Prefix:      stack.DNSPrefix,
+				IPAddress:   serviceIP,
+				Records:     records,
+				Public:      stack.PublicDNS,
 			}); err != nil {
 				return err
 			}
 		}
 
+		// --- Reverse DNS for the VM's addresses ---
+		if stack.ReverseDNSZone != "" || stack.ReverseDNSZoneV6 != "" {
+			reverse := dns.ReverseConfig{
+				Zone:         stack.ReverseDNSZone,
+				ZoneV6:       stack.ReverseDNSZoneV6,
+				Target:       vmCfg.Hostname + "." + dns.ServiceDomain(stack.DNSDomain, stack.DNSPrefix),
+				ForwardZone:  stack.GCPDNSZone,
+				AllowReplace: stack.AllowReplace,
+			}
+			if stack.ReverseDNSZone != "" {
+				reverse.IPAddress = vmCfg.IPAddress
+			}
+			if stack.ReverseDNSZoneV6 != "" {
+				reverse.IPv6Address = vmCfg.IPv6Address
+			}
+			if err := dns.CreatePTRRecords(ctx, reverse); err != nil {
+				return err
+			}
+		}
+
 		// --- Verify 1Password secrets ---
 		if err := secrets.EnsureItems(ctx); err != nil {
 			return err
@@ -132,16 +279,3 @@ func main() {
 		return nil
 	})
 }
-
-// intConfig reads an integer config value with a default fallback.
-func intConfig(cfg *config.Config, key string, defaultVal int) int {
-	raw := cfg.Get(key)
-	if raw == "" {
-		return defaultVal
-	}
-	val, err := strconv.Atoi(raw)
-	if err != nil {
-		return defaultVal
-	}
-	return val
-}
@@ -0,0 +1,196 @@
+// Package acme provisions the GCP credentials Caddy uses to obtain
+// certificates through ACME DNS-01 challenges.
+//
+// The service records live in a private zone, so no CA can reach the
+// services for HTTP-01, nor resolve records in that zone. DNS-01 only
+// needs a TXT record under _acme-challenge in the public zone for the same
+// domain: the CA sees the challenge, never the A records. This package
+// creates a service account per VM with dns.admin on that public zone
+// alone and returns an op:// reference for the Caddy role to read its key
+// from.
+//
+// The key itself is a Pulumi secret in the (encrypted) stack state, which
+// is what lets `pulumi up` notice a deleted key and recreate it. The
+// program only exports it (KeyOutput); antarctica-infra stores it in
+// 1Password with StoreKey once an update has succeeded, so previews and
+// failed updates never write to the vault.
+package acme
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/secrets"
+	"github.com/pulumi/pulumi-gcp/sdk/v8/go/gcp/dns"
+	"github.com/pulumi/pulumi-gcp/sdk/v8/go/gcp/projects"
+	"github.com/pulumi/pulumi-gcp/sdk/v8/go/gcp/serviceaccount"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// CredentialsField is the 1Password field holding the key file.
+const CredentialsField = "credentials"
+
+// KeyOutput is the secret stack output holding the Key to store.
+const KeyOutput = "acme_dns_key"
+
+// Config describes the DNS-01 credentials of one VM.
+type Config struct {
+	// Public managed zone challenge records are written to.
+	Zone string
+	// VM hostname; names the service account and the 1Password item.
+	Hostname string
+	// 1Password vault the key is stored in.
+	Vault string
+}
+
+// Credentials locate the service account key.
+type Credentials struct {
+	// op:// reference of the JSON key file.
+	Reference pulumi.StringOutput
+	// GCP project of the service account and zone.
+	Project pulumi.StringOutput
+	// Key to export as KeyOutput, as a secret.
+	Key pulumi.StringMapOutput
+}
+
+// Key is the service account key and the item it belongs in.
+type Key struct {
+	Vault        string `json:"vault"`
+	Title        string `json:"title"`
+	Credentials  string `json:"credentials"`
+	PrivateKeyID string `json:"private_key_id"`
+}
+
+// ItemTitle is the 1Password item holding the key of hostname's account.
+func ItemTitle(hostname string) string {
+	return "antarctica_acme_dns_" + hostname
+}
+
+// Reference is the op:// reference of the key file in an item.
+func Reference(vault, title string) string {
+	return fmt.Sprintf("op://%s/%s/%s", vault, title, CredentialsField)
+}
+
+// invalidAccountChars matches what a service account ID may not contain.
+var invalidAccountChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// invalidRoleChars matches what a custom role ID may not contain.
+var invalidRoleChars = regexp.MustCompile(`[^a-zA-Z0-9_.]+`)
+
+// AccountID derives the service account ID (6-30 characters of
+// [a-z0-9-]) from a hostname.
+func AccountID(hostname string) string {
+	id := "acme-" + invalidAccountChars.ReplaceAllString(strings.ToLower(hostname), "-")
+	if len(id) > 30 {
+		id = id[:30]
+	}
+	return strings.TrimRight(id, "-")
+}
+
+// RoleID derives the ID of the zone listing role (at most 64 characters
+// of [a-zA-Z0-9_.]) from a hostname.
+func RoleID(hostname string) string {
+	id := "acmeZoneList_" + invalidRoleChars.ReplaceAllString(hostname, "_")
+	if len(id) > 64 {
+		id = id[:64]
+	}
+	return id
+}
+
+// Provision creates the service account, grants it the zone and creates
+// a key for StoreKey to put in 1Password.
+func Provision(ctx *pulumi.Context, cfg Config) (*Credentials, error) {
+	if !secrets.CLIAvailable() {
+		return nil, fmt.Errorf("storing the ACME DNS-01 key needs the 1Password CLI (op) in PATH; " +
+			"unset antarctica:acme_dns_zone to keep Caddy on its internal CA")
+	}
+
+	account, err := serviceaccount.NewAccount(ctx, "acme-dns", &serviceaccount.AccountArgs{
+		AccountId:   pulumi.String(AccountID(cfg.Hostname)),
+		DisplayName: pulumi.Sprintf("ACME DNS-01 for %s", cfg.Hostname),
+		Description: pulumi.Sprintf("Caddy on %s answers DNS-01 challenges in %s", cfg.Hostname, cfg.Zone),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating ACME service account: %w", err)
+	}
+
+	// dns.admin on the one zone is what reads and writes the TXT records.
+	if _, err := dns.NewDnsManagedZoneIamMember(ctx, "acme-dns-zone-admin", &dns.DnsManagedZoneIamMemberArgs{
+		ManagedZone: pulumi.String(cfg.Zone),
+		Role:        pulumi.String("roles/dns.admin"),
+		Member:      account.Member,
+	}); err != nil {
+		return nil, fmt.Errorf("granting dns.admin on %s: %w", cfg.Zone, err)
+	}
+	// Caddy finds the zone by listing the project's zones, which no zone
+	// role can grant. A custom role holding only that permission shows the
+	// account the zone names, not the records of the other zones.
+	lister, err := projects.NewIAMCustomRole(ctx, "acme-dns-zone-list", &projects.IAMCustomRoleArgs{
+		Project:     account.Project,
+		RoleId:      pulumi.String(RoleID(cfg.Hostname)),
+		Title:       pulumi.Sprintf("ACME zone listing for %s", cfg.Hostname),
+		Permissions: pulumi.StringArray{pulumi.String("dns.managedZones.list")},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating the zone listing role: %w", err)
+	}
+	if _, err := projects.NewIAMMember(ctx, "acme-dns-zone-list", &projects.IAMMemberArgs{
+		Project: account.Project,
+		Role:    lister.Name,
+		Member:  account.Member,
+	}); err != nil {
+		return nil, fmt.Errorf("granting the zone listing role: %w", err)
+	}
+
+	key, err := serviceaccount.NewKey(ctx, "acme-dns-key", &serviceaccount.KeyArgs{
+		ServiceAccountId: account.Name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating ACME service account key: %w", err)
+	}
+
+	title := ItemTitle(cfg.Hostname)
+	exported := pulumi.All(key.Name, key.PrivateKey).ApplyT(func(args []interface{}) (map[string]string, error) {
+		// GCP returns the key file base64 encoded.
+		keyFile, err := base64.StdEncoding.DecodeString(args[1].(string))
+		if err != nil {
+			return nil, fmt.Errorf("decoding ACME service account key: %w", err)
+		}
+		keyName := args[0].(string)
+		return map[string]string{
+			"vault":          cfg.Vault,
+			"title":          title,
+			"credentials":    string(keyFile),
+			"private_key_id": keyName[strings.LastIndex(keyName, "/")+1:],
+		}, nil
+	}).(pulumi.StringMapOutput)
+
+	return &Credentials{
+		Reference: pulumi.String(Reference(cfg.Vault, title)).ToStringOutput(),
+		Project:   account.Project,
+		Key:       pulumi.ToSecret(exported).(pulumi.StringMapOutput),
+	}, nil
+}
+
+// StoreKey writes the key exported as KeyOutput to 1Password, editing the
+// item in place when it holds another key.
+func StoreKey(value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding %q output: %w", KeyOutput, err)
+	}
+	var key Key
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return fmt.Errorf("decoding %q output: %w", KeyOutput, err)
+	}
+	if err := secrets.SyncItem(key.Vault, key.Title, map[string]string{
+		CredentialsField: key.Credentials,
+		"private_key_id": key.PrivateKeyID,
+	}); err != nil {
+		return fmt.Errorf("storing the ACME DNS-01 key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Package capacity checks whether a Proxmox node can take a VM at the
+// size the stack config asks for.
+//
+// Raising cpu_cores or memory_mb, or adding VMs, only fails at `pulumi up`
+// time if Proxmox refuses to start the VM, and often not even then: an
+// overcommitted node starts everything and degrades later. Evaluate
+// compares what the node has with what its other VMs are allocated plus
+// the request, and grades each resource: within the physical capacity is
+// fine, up to the configured overcommit ratio is a warning, beyond it is
+// refused. Stopped VMs count as allocated, since they can be started.
+package capacity
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi"
+)
+
+// Default overcommit ratios. vCPUs are time-shared, memory is not:
+// without ballooning, overcommitted RAM ends with the OOM killer.
+const (
+	DefaultCPURatio    = 4.0
+	DefaultMemoryRatio = 1.0
+)
+
+// StorageWarnPercent is the pool usage above which a request is warned
+// about.
+const StorageWarnPercent = 90
+
+// Limits are the overcommit ratios beyond which a request is refused.
+type Limits struct {
+	// Allocated vCPUs may reach CPURatio x the node's logical CPUs.
+	CPURatio float64
+	// Allocated memory may reach MemoryRatio x the node's RAM.
+	MemoryRatio float64
+}
+
+// Request is the VM as the stack config sizes it.
+type Request struct {
+	VMID     int
+	VCPUs    int
+	MemoryMB int
+	// Total disk size the config asks for, and what the VM already has in
+	// the pool (0 for a new VM); only the difference is newly allocated.
+	DiskGB        int
+	CurrentDiskGB int
+	StoragePool   string
+}
+
+// Node is the live state of the node the VM runs on.
+type Node struct {
+	Name    string
+	Status  *pveapi.NodeStatus
+	VMs     []pveapi.VMSummary
+	Storage *pveapi.StorageStatus
+}
+
+// Level grades a resource.
+type Level int
+
+// Levels in increasing severity.
+const (
+	OK Level = iota
+	Warn
+	Refuse
+)
+
+// String implements fmt.Stringer.
+func (l Level) String() string {
+	switch l {
+	case Warn:
+		return "WARN"
+	case Refuse:
+		return "REFUSE"
+	}
+	return "ok"
+}
+
+// Row is one graded resource.
+type Row struct {
+	Resource string
+	Unit     string
+	// Physical capacity, allocation to other VMs, this VM's request, and
+	// the refusal limit.
+	Capacity, Others, Requested, Limit float64
+	Level                              Level
+	Note                               string
+}
+
+// After is the allocation once the request is applied.
+func (r Row) After() float64 {
+	return r.Others + r.Requested
+}
+
+// Report is the outcome of Evaluate.
+type Report struct {
+	Node string
+	Rows []Row
+}
+
+// Level is the most severe level of any row.
+func (r *Report) Level() Level {
+	level := OK
+	for _, row := range r.Rows {
+		if row.Level > level {
+			level = row.Level
+		}
+	}
+	return level
+}
+
+// Evaluate grades CPU, memory and the storage pool of node against req.
+func Evaluate(node Node, req Request, limits Limits) *Report {
+	var otherCPUs, otherMem float64
+	for _, vm := range node.VMs {
+		if int(vm.VMID) == req.VMID || vm.Template == 1 {
+			continue
+		}
+		otherCPUs += float64(vm.CPUs)
+		otherMem += float64(vm.MaxMem) / mib
+	}
+
+	report := &Report{Node: node.Name}
+	report.Rows = append(report.Rows,
+		overcommit("CPU", "vCPU", float64(node.Status.CPUInfo.CPUs), otherCPUs, float64(req.VCPUs), limits.CPURatio),
+		overcommit("Memory", "MiB", float64(node.Status.Memory.Total)/mib, otherMem, float64(req.MemoryMB), limits.MemoryRatio),
+	)
+	if node.Storage != nil {
+		report.Rows = append(report.Rows, storage(req, node.Storage))
+	}
+	return report
+}
+
+const (
+	mib = 1 << 20
+	gib = 1 << 30
+)
+
+// overcommit grades a time-shared resource against its physical capacity
+// and the overcommit ratio.
+func overcommit(resource, unit string, capacity, others, requested, ratio float64) Row {
+	row := Row{
+		Resource:  resource,
+		Unit:      unit,
+		Capacity:  capacity,
+		Others:    others,
+		Requested: requested,
+		Limit:     capacity * ratio,
+	}
+	switch after := row.After(); {
+	case after > row.Limit:
+		row.Level = Refuse
+		row.Note = fmt.Sprintf("%.1fx overcommit exceeds the %.1fx limit", after/capacity, ratio)
+	case after > capacity:
+		row.Level = Warn
+		row.Note = fmt.Sprintf("%.1fx overcommit", after/capacity)
+	}
+	return row
+}
+
+// storage grades the disk growth against the free space of the pool.
+// Usage of a shared pool includes other nodes.
+func storage(req Request, pool *pveapi.StorageStatus) Row {
+	grow := float64(req.DiskGB - req.CurrentDiskGB)
+	if grow < 0 {
+		grow = 0
+	}
+	total := float64(pool.Total) / gib
+	row := Row{
+		Resource:  "Storage " + req.StoragePool,
+		Unit:      "GiB",
+		Capacity:  total,
+		Others:    float64(pool.Used) / gib,
+		Requested: grow,
+		Limit:     float64(pool.Used+pool.Avail) / gib,
+	}
+	switch after := row.After(); {
+	case after > row.Limit:
+		row.Level = Refuse
+		row.Note = fmt.Sprintf("needs %.0f GiB, %.0f GiB free", grow, float64(pool.Avail)/gib)
+	case total > 0 && after/total*100 > StorageWarnPercent:
+		row.Level = Warn
+		row.Note = fmt.Sprintf("pool %.0f%% full afterwards", after/total*100)
+	}
+	return row
+}
+
+// Print writes the report as a table.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Capacity of %s:\n", r.Node)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  RESOURCE\tCAPACITY\tOTHER VMS\tTHIS VM\tAFTER\tLIMIT\tSTATUS")
+	for _, row := range r.Rows {
+		status := row.Level.String()
+		if row.Note != "" {
+			status += " (" + row.Note + ")"
+		}
+		fmt.Fprintf(tw, "  %s\t%.0f %s\t%.0f\t%.0f\t%.0f\t%.0f\t%s\n",
+			row.Resource, row.Capacity, row.Unit, row.Others, row.Requested, row.After(), row.Limit, status)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,108 @@
+package capacity
+
+import (
+	"testing"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi"
+)
+
+// node returns a 16-thread, 64 GiB node with a 1000 GiB pool of which
+// 500 GiB are used, and the given VMs.
+func node(vms ...pveapi.VMSummary) Node {
+	status := &pveapi.NodeStatus{}
+	status.CPUInfo.CPUs = 16
+	status.Memory.Total = 64 * gib
+	return Node{
+		Name:    "m0x-01",
+		Status:  status,
+		VMs:     vms,
+		Storage: &pveapi.StorageStatus{Total: 1000 * gib, Used: 500 * gib, Avail: 500 * gib},
+	}
+}
+
+func vm(id, cpus, memGiB int) pveapi.VMSummary {
+	return pveapi.VMSummary{VMID: pveapi.Int(id), CPUs: pveapi.Int(cpus), MaxMem: pveapi.Int(memGiB * gib)}
+}
+
+var limits = Limits{CPURatio: DefaultCPURatio, MemoryRatio: DefaultMemoryRatio}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name string
+		node Node
+		req  Request
+		want [3]Level // CPU, memory, storage
+	}{
+		{
+			name: "fits",
+			node: node(vm(100, 4, 16)),
+			req:  Request{VMID: 200, VCPUs: 8, MemoryMB: 32768, DiskGB: 230},
+			want: [3]Level{OK, OK, OK},
+		},
+		{
+			name: "CPU overcommit within the ratio",
+			node: node(vm(100, 16, 8)),
+			req:  Request{VMID: 200, VCPUs: 16, MemoryMB: 8192},
+			want: [3]Level{Warn, OK, OK},
+		},
+		{
+			name: "CPU beyond the ratio",
+			node: node(vm(100, 60, 8)),
+			req:  Request{VMID: 200, VCPUs: 8, MemoryMB: 8192},
+			want: [3]Level{Refuse, OK, OK},
+		},
+		{
+			name: "memory beyond the ratio",
+			node: node(vm(100, 4, 48)),
+			req:  Request{VMID: 200, VCPUs: 4, MemoryMB: 32768},
+			want: [3]Level{OK, Refuse, OK},
+		},
+		{
+			name: "the VM's own allocation and templates do not count",
+			node: node(vm(200, 16, 60), pveapi.VMSummary{VMID: 9000, CPUs: 64, MaxMem: 64 * gib, Template: 1}),
+			req:  Request{VMID: 200, VCPUs: 16, MemoryMB: 65536},
+			want: [3]Level{OK, OK, OK},
+		},
+		{
+			name: "only disk growth is allocated",
+			node: node(),
+			req:  Request{VMID: 200, VCPUs: 4, MemoryMB: 8192, DiskGB: 600, CurrentDiskGB: 200},
+			want: [3]Level{OK, OK, OK},
+		},
+		{
+			name: "pool nearly full",
+			node: node(),
+			req:  Request{VMID: 200, VCPUs: 4, MemoryMB: 8192, DiskGB: 450},
+			want: [3]Level{OK, OK, Warn},
+		},
+		{
+			name: "pool too small",
+			node: node(),
+			req:  Request{VMID: 200, VCPUs: 4, MemoryMB: 8192, DiskGB: 501},
+			want: [3]Level{OK, OK, Refuse},
+		},
+	}
+	for _, tt := range tests {
+		report := Evaluate(tt.node, tt.req, limits)
+		if len(report.Rows) != 3 {
+			t.Fatalf("%s: %d rows, want 3", tt.name, len(report.Rows))
+		}
+		for i, row := range report.Rows {
+			if row.Level != tt.want[i] {
+				t.Errorf("%s: %s is %s, want %s (%s)", tt.name, row.Resource, row.Level, tt.want[i], row.Note)
+			}
+		}
+	}
+}
+
+func TestEvaluateWithoutStorage(t *testing.T) {
+	n := node()
+	n.Storage = nil
+	report := Evaluate(n, Request{VMID: 200, VCPUs: 80, MemoryMB: 8192}, limits)
+	if len(report.Rows) != 2 {
+		t.Fatalf("%d rows, want 2", len(report.Rows))
+	}
+	if report.Level() != Refuse {
+		t.Errorf("Level() = %s, want %s", report.Level(), Refuse)
+	}
+}
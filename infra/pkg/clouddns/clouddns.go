@@ -0,0 +1,151 @@
+// Package clouddns is a minimal client for the GCP Cloud DNS REST API.
+//
+// The Pulumi program manages records through the gcp provider. The
+// antarctica-infra CLI needs to read live record sets outside of a Pulumi
+// run, so this package covers just the calls it makes. Like the `op`
+// integration in pkg/secrets, it avoids a heavy SDK: the access token comes
+// from GOOGLE_OAUTH_ACCESS_TOKEN (set by the ESC environment
+// dev-nerds-run/gcp) or from `gcloud auth print-access-token`.
+package clouddns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Cloud DNS v1 API root.
+const DefaultBaseURL = "https://dns.googleapis.com/dns/v1/"
+
+// Client calls the Cloud DNS API for a single GCP project.
+type Client struct {
+	// API root, normally DefaultBaseURL.
+	BaseURL string
+	// GCP project ID owning the managed zones.
+	Project string
+	// OAuth2 access token.
+	Token string
+	// HTTP client used for requests.
+	HTTP *http.Client
+}
+
+// RecordSet is a Cloud DNS resource record set.
+type RecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"`
+	Rrdatas []string `json:"rrdatas"`
+}
+
+// NewFromEnv builds a client for project, falling back to GOOGLE_PROJECT
+// or CLOUDSDK_CORE_PROJECT when project is empty.
+func NewFromEnv(project string) (*Client, error) {
+	if project == "" {
+		project = firstEnv("GOOGLE_PROJECT", "GOOGLE_CLOUD_PROJECT", "CLOUDSDK_CORE_PROJECT")
+	}
+	if project == "" {
+		return nil, errors.New("no GCP project: set gcp:project in the stack config or GOOGLE_PROJECT")
+	}
+
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if token == "" {
+		out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+		if err != nil {
+			return nil, fmt.Errorf("no GOOGLE_OAUTH_ACCESS_TOKEN and `gcloud auth print-access-token` failed: %w", err)
+		}
+		token = strings.TrimSpace(string(out))
+	}
+
+	return &Client{
+		BaseURL: DefaultBaseURL,
+		Project: project,
+		Token:   token,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// ListRecordSets returns every record set in a managed zone.
+func (c *Client) ListRecordSets(ctx context.Context, zone string) ([]RecordSet, error) {
+	var all []RecordSet
+	pageToken := ""
+	for {
+		path := fmt.Sprintf("projects/%s/managedZones/%s/rrsets", url.PathEscape(c.Project), url.PathEscape(zone))
+		if pageToken != "" {
+			path += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+
+		var page struct {
+			Rrsets        []RecordSet `json:"rrsets"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Rrsets...)
+
+		if page.NextPageToken == "" {
+			return all, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// do sends a request with an optional JSON body and decodes the response.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encoding cloud dns request: %w", err)
+		}
+		body = strings.NewReader(string(b))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+"/"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloud dns %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading cloud dns response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("cloud dns %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(raw)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("decoding cloud dns response: %w", err)
+	}
+	return nil
+}
+
+// firstEnv returns the first non-empty environment variable among keys.
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,313 @@
+// Package clouddnstest is an in-memory stand-in for the GCP Cloud DNS API.
+//
+// It serves the v1 managed zone, resource record set and change endpoints
+// the gcp provider's dns.RecordSet uses, for one project. Changes are
+// applied atomically and reported as done immediately. Point the provider
+// at it with gcp:dnsCustomEndpoint set to BaseURL, and point a
+// clouddns.Client at it with the same URL.
+package clouddnstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/clouddns"
+)
+
+// Zone is a fake managed zone.
+type Zone struct {
+	Name       string `json:"name"`
+	DNSName    string `json:"dnsName"`
+	Visibility string `json:"visibility"`
+	ID         string `json:"id"`
+	Kind       string `json:"kind"`
+}
+
+// change is a Cloud DNS change, as submitted and as returned.
+type change struct {
+	ID        string               `json:"id,omitempty"`
+	Status    string               `json:"status,omitempty"`
+	Kind      string               `json:"kind,omitempty"`
+	Additions []clouddns.RecordSet `json:"additions,omitempty"`
+	Deletions []clouddns.RecordSet `json:"deletions,omitempty"`
+}
+
+// zoneState is a zone with its record sets, keyed by "name type".
+type zoneState struct {
+	Zone
+	rrsets  map[string]clouddns.RecordSet
+	changes []change
+}
+
+// Server is a fake Cloud DNS API behind an httptest server.
+type Server struct {
+	*httptest.Server
+	// GCP project served under /projects/{project}.
+	Project string
+
+	mu    sync.Mutex
+	zones map[string]*zoneState
+}
+
+// NewServer starts a fake Cloud DNS API for project. Close it when done.
+func NewServer(project string) *Server {
+	s := &Server{Project: project, zones: map[string]*zoneState{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// BaseURL returns the API root to configure as gcp:dnsCustomEndpoint.
+func (s *Server) BaseURL() string {
+	return s.URL + "/dns/v1/"
+}
+
+// AddZone creates a managed zone. dnsName must end with a dot.
+func (s *Server) AddZone(name, dnsName, visibility string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zones[name] = &zoneState{
+		Zone: Zone{
+			Name:       name,
+			DNSName:    dnsName,
+			Visibility: visibility,
+			ID:         strconv.Itoa(1000 + len(s.zones)),
+			Kind:       "dns#managedZone",
+		},
+		rrsets: map[string]clouddns.RecordSet{},
+	}
+}
+
+// RecordSets returns the record sets of a zone, sorted by name and type.
+func (s *Server) RecordSets(zone string) []clouddns.RecordSet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	z, ok := s.zones[zone]
+	if !ok {
+		return nil
+	}
+	return z.sorted("", "")
+}
+
+// serve handles /dns/v1/projects/{project}/managedZones/...
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", "Request is missing required authentication credential.")
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/dns/v1/projects/"+s.Project+"/managedZones")
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("The requested URL %s was not found.", r.URL.Path))
+		return
+	}
+	seg := strings.Split(strings.Trim(path, "/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seg[0] == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusNotImplemented, "notImplemented", r.Method+" managedZones")
+			return
+		}
+		zones := make([]Zone, 0, len(s.zones))
+		for _, z := range s.zones {
+			zones = append(zones, z.Zone)
+		}
+		sort.Slice(zones, func(i, j int) bool { return zones[i].Name < zones[j].Name })
+		writeJSON(w, http.StatusOK, map[string]interface{}{"managedZones": zones})
+		return
+	}
+
+	z, ok := s.zones[seg[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("The 'parameters.managedZone' resource named '%s' does not exist.", seg[0]))
+		return
+	}
+
+	switch {
+	case len(seg) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, z.Zone)
+	case len(seg) == 2 && seg[1] == "rrsets" && r.Method == http.MethodGet:
+		q := r.URL.Query()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"rrsets": z.sorted(q.Get("name"), q.Get("type"))})
+	case len(seg) == 2 && seg[1] == "rrsets" && r.Method == http.MethodPost:
+		var rs clouddns.RecordSet
+		if !decode(w, r, &rs) {
+			return
+		}
+		if _, exists := z.rrsets[key(rs.Name, rs.Type)]; exists {
+			writeError(w, http.StatusConflict, "alreadyExists", fmt.Sprintf("The resource 'entity.rrset' named '%s (%s)' already exists", rs.Name, rs.Type))
+			return
+		}
+		if msg := z.validate(rs); msg != "" {
+			writeError(w, http.StatusBadRequest, "invalid", msg)
+			return
+		}
+		z.rrsets[key(rs.Name, rs.Type)] = rs
+		writeJSON(w, http.StatusOK, rs)
+	case len(seg) == 4 && seg[1] == "rrsets":
+		s.serveRecordSet(w, r, z, seg[2], seg[3])
+	case len(seg) == 2 && seg[1] == "changes" && r.Method == http.MethodPost:
+		var c change
+		if !decode(w, r, &c) {
+			return
+		}
+		if status, reason, msg := z.apply(&c); status != http.StatusOK {
+			writeError(w, status, reason, msg)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	case len(seg) == 3 && seg[1] == "changes" && r.Method == http.MethodGet:
+		for _, c := range z.changes {
+			if c.ID == seg[2] {
+				writeJSON(w, http.StatusOK, c)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("The 'parameters.changeId' resource named '%s' does not exist.", seg[2]))
+	default:
+		writeError(w, http.StatusNotImplemented, "notImplemented", r.Method+" "+r.URL.Path)
+	}
+}
+
+// serveRecordSet handles rrsets/{name}/{type}.
+func (s *Server) serveRecordSet(w http.ResponseWriter, r *http.Request, z *zoneState, name, typ string) {
+	k := key(name, typ)
+	existing, exists := z.rrsets[k]
+	if !exists {
+		writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("The 'parameters.name' resource named '%s' does not exist.", name))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, existing)
+	case http.MethodPatch:
+		var rs clouddns.RecordSet
+		if !decode(w, r, &rs) {
+			return
+		}
+		rs.Name, rs.Type = existing.Name, existing.Type
+		if msg := z.validate(rs); msg != "" {
+			writeError(w, http.StatusBadRequest, "invalid", msg)
+			return
+		}
+		z.rrsets[k] = rs
+		writeJSON(w, http.StatusOK, rs)
+	case http.MethodDelete:
+		delete(z.rrsets, k)
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+	default:
+		writeError(w, http.StatusNotImplemented, "notImplemented", r.Method+" "+r.URL.Path)
+	}
+}
+
+// apply validates and applies a change atomically: every deletion must
+// match an existing record set exactly, and additions must not collide.
+func (z *zoneState) apply(c *change) (status int, reason, msg string) {
+	next := make(map[string]clouddns.RecordSet, len(z.rrsets))
+	for k, rs := range z.rrsets {
+		next[k] = rs
+	}
+	for _, del := range c.Deletions {
+		k := key(del.Name, del.Type)
+		existing, ok := next[k]
+		if !ok || !equal(existing, del) {
+			return http.StatusPreconditionFailed, "conditionNotMet",
+				fmt.Sprintf("The resource 'entity.change.deletions[%s]' named '%s (%s)' does not exist or does not match.", del.Name, del.Name, del.Type)
+		}
+		delete(next, k)
+	}
+	for _, add := range c.Additions {
+		k := key(add.Name, add.Type)
+		if _, exists := next[k]; exists {
+			return http.StatusConflict, "alreadyExists",
+				fmt.Sprintf("The resource 'entity.change.additions[%s]' named '%s (%s)' already exists", add.Name, add.Name, add.Type)
+		}
+		if msg := z.validate(add); msg != "" {
+			return http.StatusBadRequest, "invalid", msg
+		}
+		next[k] = add
+	}
+
+	z.rrsets = next
+	c.ID = strconv.Itoa(len(z.changes) + 1)
+	c.Status = "done"
+	c.Kind = "dns#change"
+	z.changes = append(z.changes, *c)
+	return http.StatusOK, "", ""
+}
+
+// validate checks that rs belongs in the zone.
+func (z *zoneState) validate(rs clouddns.RecordSet) string {
+	if !strings.HasSuffix(rs.Name, ".") {
+		return fmt.Sprintf("Invalid value for 'entity.rrset.name': '%s'", rs.Name)
+	}
+	if rs.Name != z.DNSName && !strings.HasSuffix(rs.Name, "."+z.DNSName) {
+		return fmt.Sprintf("The resource record set '%s' is not in zone '%s'.", rs.Name, z.DNSName)
+	}
+	if len(rs.Rrdatas) == 0 {
+		return "The resource record set has no rrdatas."
+	}
+	return ""
+}
+
+// sorted returns the record sets matching the optional name and type.
+func (z *zoneState) sorted(name, typ string) []clouddns.RecordSet {
+	out := []clouddns.RecordSet{}
+	for _, rs := range z.rrsets {
+		if (name == "" || rs.Name == name) && (typ == "" || rs.Type == typ) {
+			out = append(out, rs)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return key(out[i].Name, out[i].Type) < key(out[j].Name, out[j].Type) })
+	return out
+}
+
+func key(name, typ string) string {
+	return name + " " + typ
+}
+
+func equal(a, b clouddns.RecordSet) bool {
+	if a.Name != b.Name || a.Type != b.Type || a.TTL != b.TTL || len(a.Rrdatas) != len(b.Rrdatas) {
+		return false
+	}
+	for i := range a.Rrdatas {
+		if a.Rrdatas[i] != b.Rrdatas[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "parseError", fmt.Sprintf("Invalid JSON payload received: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeError answers in the Google API error format, which the provider
+// inspects for the status code.
+func writeError(w http.ResponseWriter, status int, reason, msg string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": msg,
+			"errors":  []map[string]string{{"domain": "global", "reason": reason, "message": msg}},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,259 @@
+// Package cluster builds explicit proxmoxve providers from typed config.
+//
+// The program used to rely on the default provider, configured only by
+// the ESC environment dev-nerds-run/proxmox. Every Proxmox resource now
+// takes an explicit provider instead, one per cluster listed in
+// antarctica:proxmox_clusters, so a stack can address more than one
+// cluster. The "default" cluster is built from the proxmoxve:* config the
+// ESC environment sets, and any setting left empty still falls back to the
+// PROXMOX_VE_* environment variables the provider reads itself.
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/muhlba91/pulumi-proxmoxve/sdk/v6/go/proxmoxve"
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi"
+	"github.com/nerdsrun/antarctica/infra/pkg/secrets"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// DefaultName is the cluster configured through proxmoxve:* config.
+const DefaultName = "default"
+
+// Config describes how to reach one Proxmox cluster.
+type Config struct {
+	// API URL (e.g. "https://m0x-01.example:8006/").
+	Endpoint string `json:"endpoint"`
+	// API token in "user@realm!tokenid=secret" form. Set it as a secure
+	// value in the stack config.
+	APIToken string `json:"api_token"`
+	// op:// reference to read the API token from instead.
+	APITokenRef string `json:"api_token_ref"`
+	// Skip TLS verification, for self-signed clusters.
+	Insecure bool `json:"insecure"`
+	// Minimum TLS version (e.g. "1.3"). Empty keeps the provider default.
+	MinTLS string `json:"min_tls"`
+	// SSH access for uploads the API cannot do (cloud-init snippets).
+	SSH SSHConfig `json:"ssh"`
+
+	// Defaults for the VMs placed on this cluster. Zero values leave the
+	// foundation's (or the built-in) values in place, and a VM's own
+	// config overrides them.
+	//
+	// VM ID of the cloud-init template on this cluster.
+	TemplateVMID int `json:"template_vm_id"`
+	// Node holding that template.
+	TemplateNode string `json:"template_node"`
+	// Storage pool for VM disks.
+	StoragePool string `json:"storage_pool"`
+}
+
+// SSHConfig is the provider's SSH access to the cluster nodes.
+type SSHConfig struct {
+	// Node user; it must be able to write to the snippet datastore.
+	Username string `json:"username"`
+	// Authenticate through the local SSH agent.
+	Agent bool `json:"agent"`
+	// op:// reference to a private key, used instead of the agent.
+	PrivateKeyRef string `json:"private_key_ref"`
+	// Node addresses, for nodes whose name does not resolve.
+	Nodes []SSHNode `json:"nodes"`
+}
+
+// SSHNode maps a node name to the address the provider SSHes into.
+type SSHNode struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+}
+
+// FromProviderConfig reads the default cluster from proxmoxve:* config;
+// get returns a key within that namespace ("endpoint", "apiToken").
+func FromProviderConfig(get func(key string) string) (Config, error) {
+	cfg := Config{
+		Endpoint: get("endpoint"),
+		APIToken: get("apiToken"),
+		MinTLS:   get("minTls"),
+	}
+	cfg.Insecure, _ = strconv.ParseBool(get("insecure"))
+
+	if raw := get("ssh"); raw != "" {
+		var ssh struct {
+			Username string    `json:"username"`
+			Agent    bool      `json:"agent"`
+			Nodes    []SSHNode `json:"nodes"`
+		}
+		if err := json.Unmarshal([]byte(raw), &ssh); err != nil {
+			return Config{}, fmt.Errorf("reading proxmoxve:ssh: %w", err)
+		}
+		cfg.SSH = SSHConfig{Username: ssh.Username, Agent: ssh.Agent, Nodes: ssh.Nodes}
+	}
+	return cfg, nil
+}
+
+// Providers maps cluster names to their providers.
+type Providers map[string]*proxmoxve.Provider
+
+// Get returns the provider of a cluster.
+func (p Providers) Get(name string) (*proxmoxve.Provider, error) {
+	provider, ok := p[name]
+	if !ok {
+		names := make([]string, 0, len(p))
+		for n := range p {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown Proxmox cluster %q (configured: %v)", name, names)
+	}
+	return provider, nil
+}
+
+// WithDefault returns clusters plus the default cluster from proxmoxve:*
+// config, unless clusters already defines it.
+func WithDefault(ctx *pulumi.Context, clusters map[string]Config) (map[string]Config, error) {
+	all := make(map[string]Config, len(clusters)+1)
+	for name, cfg := range clusters {
+		all[name] = cfg
+	}
+	if _, ok := all[DefaultName]; !ok {
+		cfg, err := FromProviderConfig(config.New(ctx, "proxmoxve").Get)
+		if err != nil {
+			return nil, err
+		}
+		all[DefaultName] = cfg
+	}
+	return all, nil
+}
+
+// NewProviders creates a provider for every cluster in clusters, plus the
+// default cluster from proxmoxve:* config unless clusters overrides it.
+func NewProviders(ctx *pulumi.Context, clusters map[string]Config) (Providers, error) {
+	all, err := WithDefault(ctx, clusters)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := Providers{}
+	for name, cfg := range all {
+		p, err := NewProvider(ctx, name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = p
+	}
+	return providers, nil
+}
+
+// NewProvider creates the provider of one cluster. Tokens and keys are
+// read from 1Password at program time and kept as Pulumi secrets.
+func NewProvider(ctx *pulumi.Context, name string, cfg Config) (*proxmoxve.Provider, error) {
+	token, err := cfg.Token()
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", name, err)
+	}
+	key, err := resolve("", cfg.SSH.PrivateKeyRef)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: reading SSH key: %w", name, err)
+	}
+
+	// Only set what is configured, so the PROXMOX_VE_* variables still
+	// fill in the rest.
+	args := &proxmoxve.ProviderArgs{}
+	if cfg.Endpoint != "" {
+		args.Endpoint = pulumi.String(cfg.Endpoint)
+	}
+	if token != "" {
+		args.ApiToken = secret(token)
+	}
+	if cfg.Insecure {
+		args.Insecure = pulumi.Bool(true)
+	}
+	if cfg.MinTLS != "" {
+		args.MinTls = pulumi.String(cfg.MinTLS)
+	}
+
+	ssh := &proxmoxve.ProviderSshArgs{}
+	if cfg.SSH.Username != "" {
+		ssh.Username = pulumi.String(cfg.SSH.Username)
+	}
+	if cfg.SSH.Agent {
+		ssh.Agent = pulumi.Bool(true)
+	}
+	if key != "" {
+		ssh.PrivateKey = secret(key)
+	}
+	var nodes proxmoxve.ProviderSshNodeArray
+	for _, n := range cfg.SSH.Nodes {
+		node := proxmoxve.ProviderSshNodeArgs{
+			Name:    pulumi.String(n.Name),
+			Address: pulumi.String(n.Address),
+		}
+		if n.Port != 0 {
+			node.Port = pulumi.Int(n.Port)
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) > 0 {
+		ssh.Nodes = nodes
+	}
+	args.Ssh = ssh
+
+	provider, err := proxmoxve.NewProvider(ctx, "proxmox-"+name, args)
+	if err != nil {
+		return nil, fmt.Errorf("creating provider for cluster %s: %w", name, err)
+	}
+	return provider, nil
+}
+
+// Token returns the API token, reading it from 1Password when APITokenRef
+// is set.
+func (c Config) Token() (string, error) {
+	token, err := resolve(c.APIToken, c.APITokenRef)
+	if err != nil {
+		return "", fmt.Errorf("reading API token: %w", err)
+	}
+	return token, nil
+}
+
+// Client returns an API client for the cluster, for what the provider
+// has no resource for. Like the provider, it falls back to the
+// PROXMOX_VE_* variables for settings left empty.
+func (c Config) Client() (*pveapi.Client, error) {
+	token, err := c.Token()
+	if err != nil {
+		return nil, err
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("PROXMOX_VE_ENDPOINT")
+	}
+	if token == "" {
+		token = os.Getenv("PROXMOX_VE_API_TOKEN")
+	}
+	if endpoint == "" || token == "" {
+		return nil, errors.New("no Proxmox endpoint and API token configured " +
+			"(set them for the cluster, or run under `esc run dev-nerds-run/proxmox`)")
+	}
+	insecure, _ := strconv.ParseBool(os.Getenv("PROXMOX_VE_INSECURE"))
+	return pveapi.New(endpoint, token, c.Insecure || insecure), nil
+}
+
+// resolve returns value, or the secret behind ref when set.
+func resolve(value, ref string) (string, error) {
+	if ref == "" {
+		return value, nil
+	}
+	return secrets.Read(ref)
+}
+
+// secret wraps a credential so it never reaches the state in plaintext.
+func secret(value string) pulumi.StringOutput {
+	return pulumi.ToSecret(pulumi.String(value)).(pulumi.StringOutput)
+}
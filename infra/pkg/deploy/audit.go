@@ -0,0 +1,169 @@
+package deploy
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Deploy outcomes recorded in Record.Status.
+const (
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+// Record is one line of the audit trail.
+type Record struct {
+	// Deploy ID, matching the lock Holder.
+	ID string `json:"id"`
+	// Local user, git author email and machine the deploy ran from.
+	User  string `json:"user"`
+	Email string `json:"email,omitempty"`
+	Host  string `json:"host"`
+	// Commit deployed, and whether the working tree had local changes.
+	GitSHA   string `json:"git_sha"`
+	GitDirty bool   `json:"git_dirty"`
+	// Pulumi stack.
+	Stack string `json:"stack"`
+	// What was run (e.g. "up + site.yml").
+	Command    string    `json:"command"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Succeeded or Failed.
+	Status string `json:"status"`
+	// Failure reason when Status is Failed.
+	Error string `json:"error,omitempty"`
+	// Set when the deploy ran `pulumi up`.
+	Pulumi *PulumiSummary `json:"pulumi,omitempty"`
+	// Set when the deploy ran a playbook.
+	Ansible *AnsibleSummary `json:"ansible,omitempty"`
+}
+
+// PulumiSummary is the outcome of the `pulumi up` step.
+type PulumiSummary struct {
+	// Stack version after the update.
+	Version int `json:"version"`
+	// "succeeded", "failed", ...
+	Result string `json:"result"`
+	// Resource operation counts ("create", "update", "same", ...).
+	ResourceChanges map[string]int `json:"resource_changes,omitempty"`
+}
+
+// AnsibleSummary is the outcome of the Ansible step.
+type AnsibleSummary struct {
+	Playbook string   `json:"playbook"`
+	Args     []string `json:"args,omitempty"`
+	// Succeeded or Failed.
+	Result string `json:"result"`
+	// PLAY RECAP counts per host.
+	Recap map[string]RecapCounts `json:"recap,omitempty"`
+}
+
+// RecapCounts is one host's line of the Ansible PLAY RECAP.
+type RecapCounts struct {
+	OK          int `json:"ok"`
+	Changed     int `json:"changed"`
+	Unreachable int `json:"unreachable"`
+	Failed      int `json:"failed"`
+	Skipped     int `json:"skipped"`
+	Rescued     int `json:"rescued"`
+	Ignored     int `json:"ignored"`
+}
+
+// NewID returns a deploy ID that sorts by start time.
+func NewID(now time.Time) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// Append adds rec to the audit trail on the VM.
+func Append(ctx context.Context, r Runner, rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %w", err)
+	}
+	cmd := fmt.Sprintf("sudo install -d -m 0755 %s && sudo sh -c 'cat >> %s'", Dir, auditFile)
+	if _, err := r.RunInput(ctx, cmd, append(line, '\n')); err != nil {
+		return fmt.Errorf("appending audit record: %w", err)
+	}
+	return nil
+}
+
+// Read returns the last n audit records, oldest first.
+func Read(ctx context.Context, r Runner, n int) ([]Record, error) {
+	cmd := fmt.Sprintf("sudo sh -c 'tail -n %d %s 2>/dev/null || true'", n, auditFile)
+	out, err := r.RunInput(ctx, cmd, nil)
+	if err != nil {
+		return nil, fmt.Errorf("reading audit trail: %w", err)
+	}
+
+	var records []Record
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return nil, fmt.Errorf("decoding audit record %q: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// recapLine matches "host : ok=12 changed=1 unreachable=0 failed=0 ...".
+var recapLine = regexp.MustCompile(`^(\S+)\s+:\s+((?:\w+=\d+\s*)+)$`)
+
+// ParseRecap extracts the PLAY RECAP counts from ansible-playbook output.
+func ParseRecap(output string) map[string]RecapCounts {
+	recap := map[string]RecapCounts{}
+	inRecap := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "PLAY RECAP") {
+			inRecap = true
+			continue
+		}
+		if !inRecap {
+			continue
+		}
+		m := recapLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		var c RecapCounts
+		for _, kv := range strings.Fields(m[2]) {
+			key, val, _ := strings.Cut(kv, "=")
+			n, _ := strconv.Atoi(val)
+			switch key {
+			case "ok":
+				c.OK = n
+			case "changed":
+				c.Changed = n
+			case "unreachable":
+				c.Unreachable = n
+			case "failed":
+				c.Failed = n
+			case "skipped":
+				c.Skipped = n
+			case "rescued":
+				c.Rescued = n
+			case "ignored":
+				c.Ignored = n
+			}
+		}
+		recap[m[1]] = c
+	}
+	return recap
+}
@@ -0,0 +1,149 @@
+// Package deploy serialises deployments to the shared Antarctica server and
+// records each one in an audit trail.
+//
+// Two locks guard a deploy. Pulumi's own stack lock covers `pulumi up`; it
+// is taken by the backend for the duration of the update. The VM lock
+// covers the whole deploy, Ansible included: a file under /data created
+// with O_EXCL and stamped with who holds it. Both the lock and the audit
+// trail live on the VM's data disk so they survive reboots and are shared
+// by every operator.
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Dir holds the lock file and the audit trail on the VM.
+const Dir = "/data/antarctica-deploy"
+
+const (
+	lockFile  = Dir + "/lock"
+	auditFile = Dir + "/audit.jsonl"
+)
+
+// Runner executes a shell command on the VM with optional stdin.
+// *remote.Client satisfies it.
+type Runner interface {
+	RunInput(ctx context.Context, cmd string, input []byte) (string, error)
+}
+
+// Holder identifies the deploy holding the lock.
+type Holder struct {
+	// Unique deploy ID, shared with the audit record.
+	ID string `json:"id"`
+	// Local user running the deploy.
+	User string `json:"user"`
+	// Git author email, when configured.
+	Email string `json:"email,omitempty"`
+	// Machine the deploy runs from.
+	Host string `json:"host"`
+	// Commit being deployed.
+	GitSHA string `json:"git_sha"`
+	// Pulumi stack.
+	Stack string `json:"stack"`
+	// What is being run (e.g. "up + site.yml").
+	Command string `json:"command"`
+	// When the lock was taken.
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+// String describes the holder for error messages.
+func (h Holder) String() string {
+	who := h.User
+	if h.Email != "" {
+		who = fmt.Sprintf("%s <%s>", h.User, h.Email)
+	}
+	return fmt.Sprintf("%s on %s (%s at %.12s, since %s)",
+		who, h.Host, h.Command, h.GitSHA, h.AcquiredAt.Local().Format(time.RFC1123))
+}
+
+// LockedError is returned by Acquire when another deploy holds the lock.
+type LockedError struct {
+	Holder Holder
+}
+
+func (e *LockedError) Error() string {
+	return "deploy locked by " + e.Holder.String()
+}
+
+// Acquire takes the VM lock for h. It fails with a *LockedError when the
+// lock is held, unless force is set, in which case the stale lock is
+// replaced.
+func Acquire(ctx context.Context, r Runner, h Holder, force bool) error {
+	stamp, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("encoding deploy lock: %w", err)
+	}
+
+	// set -C (noclobber) makes the redirection fail if the file exists.
+	redirect := "set -C; cat > " + lockFile
+	if force {
+		redirect = "cat > " + lockFile
+	}
+	cmd := fmt.Sprintf("sudo install -d -m 0755 %s && sudo sh -c '%s'", Dir, redirect)
+	if _, err := r.RunInput(ctx, cmd, append(stamp, '\n')); err == nil {
+		return nil
+	}
+
+	// The write failed: report the holder if the lock exists, otherwise
+	// the write itself failed (e.g. /data is not mounted yet).
+	out, readErr := r.RunInput(ctx, "sudo cat "+lockFile, nil)
+	if readErr != nil {
+		return fmt.Errorf("taking deploy lock %s: %w", lockFile, readErr)
+	}
+	var holder Holder
+	if err := json.Unmarshal([]byte(out), &holder); err != nil {
+		return fmt.Errorf("deploy lock %s exists but is unreadable (%q); use -force-unlock", lockFile, out)
+	}
+	return &LockedError{Holder: holder}
+}
+
+// Release removes the VM lock if it still belongs to id. A lock broken and
+// retaken by someone else is left alone.
+func Release(ctx context.Context, r Runner, id string) error {
+	cmd := fmt.Sprintf("sudo sh -c 'grep -qF %s %s && rm -f %s || true'", shellQuoteInner(id), lockFile, lockFile)
+	if _, err := r.RunInput(ctx, cmd, nil); err != nil {
+		return fmt.Errorf("releasing deploy lock: %w", err)
+	}
+	return nil
+}
+
+// Current returns the current lock holder, or nil when unlocked.
+func Current(ctx context.Context, r Runner) (*Holder, error) {
+	out, err := r.RunInput(ctx, fmt.Sprintf("sudo sh -c 'cat %s 2>/dev/null || true'", lockFile), nil)
+	if err != nil {
+		return nil, fmt.Errorf("reading deploy lock: %w", err)
+	}
+	if strings.TrimSpace(out) == "" {
+		return nil, nil
+	}
+	var holder Holder
+	if err := json.Unmarshal([]byte(out), &holder); err != nil {
+		return nil, fmt.Errorf("decoding deploy lock: %w", err)
+	}
+	return &holder, nil
+}
+
+// IsLocked reports whether err is a *LockedError.
+func IsLocked(err error) bool {
+	var locked *LockedError
+	return errors.As(err, &locked)
+}
+
+// shellQuoteInner quotes s for use inside a single-quoted sh -c script,
+// where it becomes a double-quoted word. IDs are hex, so nothing needs
+// escaping in practice; anything unexpected is dropped.
+func shellQuoteInner(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	return `"` + b.String() + `"`
+}
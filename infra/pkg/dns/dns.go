@@ -2,6 +2,11 @@
 //
 // DNS records are created in the dev.nerds.run private zone, pointing
 // service subdomains (forgejo, woodpecker, etc.) to the VM's IP address.
+// The subdomains are the Caddy sites Ansible deploys (see LoadSites), so
+// adding a site publishes its name and removing one deletes its record.
+// Sites marked public are also published in a public zone for the same
+// domain, pointing at an ingress address, so internal and external clients
+// get different answers for the same name (see PublicConfig).
 // GCP credentials come from the Pulumi ESC environment dev-nerds-run/gcp.
 package dns
 
@@ -18,25 +23,46 @@ type Config struct {
 	ManagedZone string
 	// Base domain (e.g. "dev.nerds.run")
 	Domain string
+	// Optional label between subdomain and domain (e.g. "pr-42" creates
+	// forgejo.pr-42.dev.nerds.run)
+	Prefix string
 	// VM IP address (Pulumi output from VM provisioning)
 	IPAddress pulumi.StringOutput
+	// Service records, one per Caddy site (see LoadSites)
+	Records []Record
+	// Public twins of the records marked Public (split-horizon).
+	Public PublicConfig
 }
 
+// DefaultTTL is the TTL in seconds applied to every service record.
+const DefaultTTL = 300
+
 // Record describes a single DNS A record to create.
 type Record struct {
 	// Subdomain prefix (e.g. "forgejo" creates forgejo.dev.nerds.run)
 	Subdomain string
+	// Also publish the name in the public zone (see PublicConfig); other
+	// records stay private-only.
+	Public bool
+}
+
+// FQDN returns the record's fully qualified name, with trailing dot.
+func (r Record) FQDN(domain string) string {
+	return fmt.Sprintf("%s.%s.", r.Subdomain, domain)
+}
+
+// ResourceName returns the Pulumi resource name used for the record.
+func (r Record) ResourceName() string {
+	return fmt.Sprintf("dns-%s", r.Subdomain)
 }
 
-// DefaultRecords returns the DNS records needed for Antarctica services.
-func DefaultRecords() []Record {
-	return []Record{
-		{Subdomain: "forgejo"},
-		{Subdomain: "woodpecker"},
-		{Subdomain: "registry"},
-		{Subdomain: "vscode"},
-		{Subdomain: "cockpit"},
+// ServiceDomain returns the domain service records are created under:
+// domain itself, or prefix.domain on preview environments.
+func ServiceDomain(domain, prefix string) string {
+	if prefix == "" {
+		return domain
 	}
+	return prefix + "." + domain
 }
 
 // CreateRecords creates DNS A records in GCP Cloud DNS for each service.
@@ -44,11 +70,20 @@ func DefaultRecords() []Record {
 // deploy the QEMU guest agent may not have reported the IP yet; a subsequent
 // `pulumi refresh && pulumi up` will create the records once the IP appears.
 func CreateRecords(ctx *pulumi.Context, cfg Config) error {
-	records := DefaultRecords()
+	if err := cfg.Public.Validate(); err != nil {
+		return fmt.Errorf("invalid public DNS: %w", err)
+	}
+	if len(cfg.Records) == 0 {
+		return fmt.Errorf("no service records; %s lists no Caddy sites", SitesVar)
+	}
 
-	for _, rec := range records {
-		fqdn := fmt.Sprintf("%s.%s.", rec.Subdomain, cfg.Domain)
-		resourceName := fmt.Sprintf("dns-%s", rec.Subdomain)
+	domain := ServiceDomain(cfg.Domain, cfg.Prefix)
+
+	// Records follow the Caddy sites, so unlike the VM they are not
+	// protected: removing a site from the list deletes its record.
+	for _, rec := range cfg.Records {
+		fqdn := rec.FQDN(domain)
+		resourceName := rec.ResourceName()
 
 		// Only supply rrdatas when the IP is non-empty; GCP rejects empty A records.
 		rrdatas := cfg.IPAddress.ApplyT(func(ip string) []string {
@@ -62,7 +97,7 @@ func CreateRecords(ctx *pulumi.Context, cfg Config) error {
 			ManagedZone: pulumi.String(cfg.ManagedZone),
 			Name:        pulumi.String(fqdn),
 			Type:        pulumi.String("A"),
-			Ttl:         pulumi.Int(300),
+			Ttl:         pulumi.Int(DefaultTTL),
 			Rrdatas:     rrdatas,
 		})
 		if err != nil {
@@ -72,5 +107,8 @@ func CreateRecords(ctx *pulumi.Context, cfg Config) error {
 		ctx.Log.Info(fmt.Sprintf("DNS record: %s -> VM IP", fqdn), nil)
 	}
 
+	if cfg.Public.Enabled() {
+		return createPublicRecords(ctx, cfg.Public, domain, cfg.Records)
+	}
 	return nil
 }
@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pulumi/pulumi-gcp/sdk/v8/go/gcp/dns"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// PublicConfig publishes selected service records in a public zone for
+// the same domain, answering external clients with an ingress address
+// while the private zone keeps answering internal clients with the VM's
+// LAN address (split-horizon). Only records marked Public are published.
+type PublicConfig struct {
+	// Public GCP managed zone serving the same domain as the private one.
+	// Empty publishes nothing.
+	Zone string
+	// What external clients reach the services through: an IPv4 address
+	// (public ingress) or a hostname (e.g. a tunnel endpoint), published as
+	// an A or a CNAME record.
+	Address string
+}
+
+// Enabled reports whether a public zone is configured.
+func (c PublicConfig) Enabled() bool {
+	return c.Zone != ""
+}
+
+// Validate checks that a public zone comes with an address.
+func (c PublicConfig) Validate() error {
+	switch {
+	case c.Zone == "" && c.Address == "":
+		return nil
+	case c.Zone == "" || c.Address == "":
+		return errors.New("a public zone needs the ingress address, and the other way round")
+	}
+	if ip := net.ParseIP(c.Address); ip != nil && ip.To4() == nil {
+		return fmt.Errorf("public address %s: only IPv4 addresses or hostnames are supported", c.Address)
+	}
+	return nil
+}
+
+// recordType is the type of the public records: A for an address, CNAME
+// for a hostname.
+func (c PublicConfig) recordType() (string, string) {
+	if net.ParseIP(c.Address) != nil {
+		return "A", c.Address
+	}
+	return "CNAME", strings.TrimSuffix(c.Address, ".") + "."
+}
+
+// PublicResourceName returns the Pulumi resource name of the record's
+// public twin.
+func (r Record) PublicResourceName() string {
+	return fmt.Sprintf("dns-public-%s", r.Subdomain)
+}
+
+// createPublicRecords publishes the Public records in the public zone,
+// which must be public and serve domain or one of its parents.
+func createPublicRecords(ctx *pulumi.Context, cfg PublicConfig, domain string, records []Record) error {
+	zone, err := LookupZone(ctx, cfg.Zone)
+	if err != nil {
+		return err
+	}
+	if zone.Visibility == VisibilityPrivate {
+		return fmt.Errorf("managed zone %s is private; external clients cannot see it", cfg.Zone)
+	}
+	if domain != zone.Domain && !strings.HasSuffix(domain, "."+zone.Domain) {
+		return fmt.Errorf("service domain %s is not within public zone %s (%s)", domain, cfg.Zone, zone.Domain)
+	}
+
+	recordType, target := cfg.recordType()
+	for _, rec := range records {
+		if !rec.Public {
+			continue
+		}
+		fqdn := rec.FQDN(domain)
+		_, err := dns.NewRecordSet(ctx, rec.PublicResourceName(), &dns.RecordSetArgs{
+			ManagedZone: pulumi.String(cfg.Zone),
+			Name:        pulumi.String(fqdn),
+			Type:        pulumi.String(recordType),
+			Ttl:         pulumi.Int(DefaultTTL),
+			Rrdatas:     pulumi.StringArray{pulumi.String(target)},
+		})
+		if err != nil {
+			return fmt.Errorf("creating public DNS record for %s: %w", fqdn, err)
+		}
+		ctx.Log.Info(fmt.Sprintf("DNS record (public): %s -> %s", fqdn, target), nil)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pulumi/pulumi-gcp/sdk/v8/go/gcp/dns"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// ReverseConfig holds the parameters for PTR record creation. Each address
+// family is optional: set its managed zone and address, or neither.
+type ReverseConfig struct {
+	// GCP managed zone of the IPv4 reverse zone (e.g. "rev-202-22-172").
+	Zone string
+	// Static IPv4 address in CIDR notation (e.g. "172.22.202.50/24").
+	IPAddress string
+	// GCP managed zone of the IPv6 reverse zone.
+	ZoneV6 string
+	// Static IPv6 address in CIDR notation (e.g. "2001:db8:0:1::50/64").
+	IPv6Address string
+	// Name the PTR records point at (e.g. "antarctica.dev.nerds.run").
+	Target string
+	// GCP managed zone to publish Target's A and AAAA records in, so the
+	// PTR records are forward-confirmed. Empty skips them.
+	ForwardZone string
+	// Drop delete/replace protection on the records (see vm.Config.AllowReplace).
+	AllowReplace bool
+}
+
+// ReverseZone returns the reverse zone an address in CIDR notation falls
+// into (e.g. "172.22.202.50/24" -> "202.22.172.in-addr.arpa."). The zone
+// covers the whole octets (IPv4) or nibbles (IPv6) of the prefix, so a
+// /20 lives in the /16 zone and a /26 in its /24 zone.
+func ReverseZone(cidr string) (string, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("parsing %q: %w", cidr, err)
+	}
+	prefix, _ := network.Mask.Size()
+
+	labels := reverseLabels(ip)
+	var keep int
+	if ip.To4() != nil {
+		if prefix < 8 {
+			return "", fmt.Errorf("%s: a /%d prefix is too short for a reverse zone", cidr, prefix)
+		}
+		keep = min(prefix/8, 3)
+	} else {
+		if prefix < 4 {
+			return "", fmt.Errorf("%s: a /%d prefix is too short for a reverse zone", cidr, prefix)
+		}
+		keep = min(prefix/4, 31)
+	}
+	// labels is least significant first, followed by the arpa suffix.
+	suffix := labels[len(labels)-2:]
+	labels = labels[:len(labels)-2]
+	return strings.Join(append(labels[len(labels)-keep:], suffix...), ".") + ".", nil
+}
+
+// PTRName returns the PTR record name of an address (e.g. "172.22.202.50"
+// -> "50.202.22.172.in-addr.arpa.").
+func PTRName(ip net.IP) string {
+	return strings.Join(reverseLabels(ip), ".") + "."
+}
+
+// reverseLabels returns the labels of ip's reverse name, least
+// significant first, ending in "in-addr", "arpa" or "ip6", "arpa".
+func reverseLabels(ip net.IP) []string {
+	var labels []string
+	if v4 := ip.To4(); v4 != nil {
+		for i := len(v4) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprint(v4[i]))
+		}
+		return append(labels, "in-addr", "arpa")
+	}
+	v6 := ip.To16()
+	for i := len(v6) - 1; i >= 0; i-- {
+		labels = append(labels, fmt.Sprintf("%x", v6[i]&0x0f), fmt.Sprintf("%x", v6[i]>>4))
+	}
+	return append(labels, "ip6", "arpa")
+}
+
+// Validate checks that every configured family has both a zone and an
+// address of that family.
+func (c ReverseConfig) Validate() error {
+	var errs []error
+	check := func(family, zone, cidr string, v4 bool) {
+		if zone == "" && cidr == "" {
+			return
+		}
+		if zone == "" || cidr == "" {
+			errs = append(errs, fmt.Errorf("%s reverse DNS needs both a reverse zone and a static address", family))
+			return
+		}
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil || (ip.To4() != nil) != v4 {
+			errs = append(errs, fmt.Errorf("%q is not an %s address in CIDR notation", cidr, family))
+		}
+	}
+	check("IPv4", c.Zone, c.IPAddress, true)
+	check("IPv6", c.ZoneV6, c.IPv6Address, false)
+	if c.Target == "" {
+		errs = append(errs, errors.New("reverse DNS needs a target name"))
+	}
+	return errors.Join(errs...)
+}
+
+// CreatePTRRecords creates the PTR records of the configured addresses,
+// and the matching forward records when ForwardZone is set. The reverse
+// zone is computed from each address's prefix and must be the one the
+// named managed zone serves.
+func CreatePTRRecords(ctx *pulumi.Context, cfg ReverseConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid reverse DNS config: %w", err)
+	}
+
+	var opts []pulumi.ResourceOption
+	if !cfg.AllowReplace {
+		opts = append(opts, pulumi.Protect(true), pulumi.RetainOnDelete(true))
+	}
+	target := strings.TrimSuffix(cfg.Target, ".") + "."
+
+	for _, family := range []struct {
+		name, zone, cidr, forwardType string
+	}{
+		{"v4", cfg.Zone, cfg.IPAddress, "A"},
+		{"v6", cfg.ZoneV6, cfg.IPv6Address, "AAAA"},
+	} {
+		if family.zone == "" {
+			continue
+		}
+		want, err := ReverseZone(family.cidr)
+		if err != nil {
+			return err
+		}
+		zone, err := LookupZone(ctx, family.zone)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(zone.Domain+".", want) {
+			return fmt.Errorf("managed zone %s serves %s., but %s belongs in %s",
+				family.zone, zone.Domain, family.cidr, want)
+		}
+
+		ip, _, _ := net.ParseCIDR(family.cidr)
+		name := PTRName(ip)
+		_, err = dns.NewRecordSet(ctx, "ptr-"+family.name, &dns.RecordSetArgs{
+			ManagedZone: pulumi.String(family.zone),
+			Name:        pulumi.String(name),
+			Type:        pulumi.String("PTR"),
+			Ttl:         pulumi.Int(DefaultTTL),
+			Rrdatas:     pulumi.StringArray{pulumi.String(target)},
+		}, opts...)
+		if err != nil {
+			return fmt.Errorf("creating PTR record %s: %w", name, err)
+		}
+		ctx.Log.Info(fmt.Sprintf("DNS record: %s PTR %s", name, target), nil)
+
+		if cfg.ForwardZone == "" {
+			continue
+		}
+		_, err = dns.NewRecordSet(ctx, "host-"+family.name, &dns.RecordSetArgs{
+			ManagedZone: pulumi.String(cfg.ForwardZone),
+			Name:        pulumi.String(target),
+			Type:        pulumi.String(family.forwardType),
+			Ttl:         pulumi.Int(DefaultTTL),
+			Rrdatas:     pulumi.StringArray{pulumi.String(ip.String())},
+		}, opts...)
+		if err != nil {
+			return fmt.Errorf("creating %s record %s: %w", family.forwardType, target, err)
+		}
+		ctx.Log.Info(fmt.Sprintf("DNS record: %s %s %s", target, family.forwardType, ip), nil)
+	}
+	return nil
+}
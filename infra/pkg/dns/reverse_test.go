@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReverseZone(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want string
+	}{
+		{"172.22.202.50/24", "202.22.172.in-addr.arpa."},
+		{"172.22.202.50/20", "22.172.in-addr.arpa."},
+		{"172.22.202.50/16", "22.172.in-addr.arpa."},
+		{"172.22.202.50/26", "202.22.172.in-addr.arpa."},
+		{"172.22.202.50/32", "202.22.172.in-addr.arpa."},
+		{"10.1.2.3/8", "10.in-addr.arpa."},
+		{"2001:db8:0:1::50/64", "1.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8:0:1::50/48", "0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8:0:1::50/62", "0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8::/32", "8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8::1/128", "0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	}
+	for _, tt := range tests {
+		got, err := ReverseZone(tt.cidr)
+		if err != nil {
+			t.Errorf("ReverseZone(%q): %v", tt.cidr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ReverseZone(%q) = %q, want %q", tt.cidr, got, tt.want)
+		}
+	}
+}
+
+func TestReverseZoneErrors(t *testing.T) {
+	for _, cidr := range []string{"172.22.202.50", "172.22.202.50/7", "2001:db8::1/3", "not-an-address/24"} {
+		if got, err := ReverseZone(cidr); err == nil {
+			t.Errorf("ReverseZone(%q) = %q, want an error", cidr, got)
+		}
+	}
+}
+
+func TestPTRName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"172.22.202.50", "50.202.22.172.in-addr.arpa."},
+		{"::ffff:172.22.202.50", "50.202.22.172.in-addr.arpa."},
+		{"2001:db8:0:1::50", "0.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	}
+	for _, tt := range tests {
+		if got := PTRName(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("PTRName(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestReverseConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ReverseConfig
+		wantErr bool
+	}{
+		{"ipv4", ReverseConfig{Zone: "rev", IPAddress: "172.22.202.50/24", Target: "antarctica.dev.nerds.run"}, false},
+		{"both families", ReverseConfig{Zone: "rev", IPAddress: "172.22.202.50/24", ZoneV6: "rev6",
+			IPv6Address: "2001:db8:0:1::50/64", Target: "antarctica.dev.nerds.run"}, false},
+		{"zone without address", ReverseConfig{Zone: "rev", Target: "antarctica.dev.nerds.run"}, true},
+		{"ipv6 address in the ipv4 zone", ReverseConfig{Zone: "rev", IPAddress: "2001:db8::1/64", Target: "a"}, true},
+		{"no target", ReverseConfig{Zone: "rev", IPAddress: "172.22.202.50/24"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SitesVar is the Ansible variable listing the Caddy sites.
+const SitesVar = "caddy_sites"
+
+// Site is one entry of SitesVar. Only the name and visibility matter
+// here; the other keys configure the reverse proxy.
+type Site struct {
+	// Subdomain the site is served on (e.g. "forgejo").
+	Name string `yaml:"name"`
+	// Also reachable from outside (see PublicConfig).
+	Public bool `yaml:"public"`
+}
+
+// labelPattern matches a single DNS label.
+var labelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// LoadSites reads the Caddy sites from an Ansible vars file and returns a
+// record per site. A file without sites is an error rather than an empty
+// list, since every existing record would otherwise be deleted.
+func LoadSites(path string) ([]Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading Caddy sites: %w", err)
+	}
+	var vars map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	node, ok := vars[SitesVar]
+	if !ok {
+		return nil, fmt.Errorf("%s does not define %s", path, SitesVar)
+	}
+	var sites []Site
+	if err := node.Decode(&sites); err != nil {
+		return nil, fmt.Errorf("%s in %s: %w", SitesVar, path, err)
+	}
+	if len(sites) == 0 {
+		return nil, fmt.Errorf("%s in %s is empty", SitesVar, path)
+	}
+
+	var errs []error
+	seen := map[string]bool{}
+	records := make([]Record, 0, len(sites))
+	for _, s := range sites {
+		switch {
+		case !labelPattern.MatchString(s.Name):
+			errs = append(errs, fmt.Errorf("site name %q is not a DNS label", s.Name))
+		case seen[s.Name]:
+			errs = append(errs, fmt.Errorf("site %s is listed twice", s.Name))
+		default:
+			seen[s.Name] = true
+			records = append(records, Record{Subdomain: s.Name, Public: s.Public})
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("%s in %s: %w", SitesVar, path, err)
+	}
+	return records, nil
+}
@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeVars writes an Ansible vars file and returns its path.
+func writeVars(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "antarctica.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSites(t *testing.T) {
+	path := writeVars(t, `
+caddy_domain: dev.nerds.run
+caddy_sites:
+  - name: forgejo
+    port: 3000
+    public: true
+  - name: vscode
+    port: 3100
+    flush_interval: -1
+`)
+	got, err := LoadSites(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Record{{Subdomain: "forgejo", Public: true}, {Subdomain: "vscode"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadSites() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadSitesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"missing", "caddy_domain: dev.nerds.run\n", "does not define caddy_sites"},
+		{"empty", "caddy_sites: []\n", "is empty"},
+		{"not a list", "caddy_sites: forgejo\n", "caddy_sites in"},
+		{"invalid label", "caddy_sites:\n  - name: Forgejo_UI\n", "is not a DNS label"},
+		{"leading hyphen", "caddy_sites:\n  - name: -forgejo\n", "is not a DNS label"},
+		{"no name", "caddy_sites:\n  - port: 3000\n", "is not a DNS label"},
+		{"duplicate", "caddy_sites:\n  - name: forgejo\n  - name: forgejo\n", "listed twice"},
+		{"invalid YAML", "caddy_sites: [\n", "parsing"},
+	}
+	for _, tt := range tests {
+		_, err := LoadSites(writeVars(t, tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: LoadSites() = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadSitesMissingFile(t *testing.T) {
+	if _, err := LoadSites(filepath.Join(t.TempDir(), "missing.yml")); err == nil {
+		t.Error("LoadSites() of a missing file succeeded")
+	}
+}
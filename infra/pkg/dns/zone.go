@@ -0,0 +1,214 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pulumi/pulumi-gcp/sdk/v8/go/gcp/dns"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Zone visibilities accepted by Cloud DNS.
+const (
+	VisibilityPrivate = "private"
+	VisibilityPublic  = "public"
+)
+
+// ZoneConfig describes the Cloud DNS managed zone of the foundation stack.
+type ZoneConfig struct {
+	// GCP managed zone name (e.g. "private-dev-nerds-run").
+	Name string
+	// Domain served by the zone (e.g. "dev.nerds.run"). Optional when the
+	// zone is looked up; it is then read from the zone.
+	Domain string
+	// Free-form description shown in the GCP console.
+	Description string
+	// Create the zone. When false, an existing zone named Name is looked
+	// up and the settings below are ignored.
+	Create bool
+	// VisibilityPrivate (default) or VisibilityPublic.
+	Visibility string
+	// VPC networks a private zone is visible from, as IDs
+	// ("projects/{project}/global/networks/{network}") or URLs.
+	Networks []string
+	// Sign a public zone with DNSSEC. The DS records to register with the
+	// parent domain are exported once the keys exist.
+	DNSSEC bool
+	// IPv4 name servers a private forwarding zone sends queries to.
+	ForwardingTargets []string
+	// VPC network a private peering zone resolves names in.
+	PeeringNetwork string
+}
+
+// Validate checks the zone settings for combinations Cloud DNS rejects.
+func (c ZoneConfig) Validate() error {
+	var errs []error
+
+	if c.Name == "" {
+		errs = append(errs, errors.New("managed zone needs a name"))
+	}
+	if !c.Create {
+		return errors.Join(errs...)
+	}
+	if c.Domain == "" {
+		errs = append(errs, errors.New("managed zone needs a domain"))
+	}
+
+	switch c.Visibility {
+	case VisibilityPrivate:
+		if len(c.ForwardingTargets) > 0 && c.PeeringNetwork != "" {
+			errs = append(errs, errors.New("a zone cannot both forward and peer"))
+		}
+		if c.DNSSEC {
+			errs = append(errs, errors.New("DNSSEC is only supported on public zones"))
+		}
+	case VisibilityPublic:
+		if len(c.Networks) > 0 {
+			errs = append(errs, errors.New("VPC networks only apply to private zones"))
+		}
+		if len(c.ForwardingTargets) > 0 || c.PeeringNetwork != "" {
+			errs = append(errs, errors.New("forwarding and peering only apply to private zones"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("zone visibility must be %s or %s, got %q",
+			VisibilityPrivate, VisibilityPublic, c.Visibility))
+	}
+
+	for _, target := range c.ForwardingTargets {
+		if ip := net.ParseIP(target); ip == nil || ip.To4() == nil {
+			errs = append(errs, fmt.Errorf("forwarding target %q is not an IPv4 address", target))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Zone is the managed zone the foundation stack created or looked up.
+type Zone struct {
+	Name       string
+	Domain     string
+	Visibility string
+	// Authoritative name servers; delegate a public zone to these.
+	NameServers pulumi.StringArrayOutput
+	// DS records of the active key-signing keys. Empty unless DNSSEC is on.
+	DSRecords pulumi.StringArrayOutput
+}
+
+// EnsureZone creates the zone described by cfg, or looks it up when
+// cfg.Create is false.
+func EnsureZone(ctx *pulumi.Context, cfg ZoneConfig) (*Zone, error) {
+	if !cfg.Create {
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		return LookupZone(ctx, cfg.Name)
+	}
+
+	zone, err := CreateZone(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ds := pulumi.StringArray{}.ToStringArrayOutput()
+	if cfg.DNSSEC {
+		ds = dsRecords(ctx, zone.Name)
+	}
+	return &Zone{
+		Name:        cfg.Name,
+		Domain:      strings.TrimSuffix(cfg.Domain, "."),
+		Visibility:  cfg.Visibility,
+		NameServers: zone.NameServers,
+		DSRecords:   ds,
+	}, nil
+}
+
+// CreateZone creates a managed zone. Every service record lives in it, so
+// it is protected like the records themselves.
+func CreateZone(ctx *pulumi.Context, cfg ZoneConfig) (*dns.ManagedZone, error) {
+	cfg.Create = true
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("managed zone %s: %w", cfg.Name, err)
+	}
+
+	args := &dns.ManagedZoneArgs{
+		Name:        pulumi.String(cfg.Name),
+		DnsName:     pulumi.String(strings.TrimSuffix(cfg.Domain, ".") + "."),
+		Description: pulumi.String(cfg.Description),
+		Visibility:  pulumi.String(cfg.Visibility),
+	}
+
+	if cfg.Visibility == VisibilityPrivate {
+		networks := dns.ManagedZonePrivateVisibilityConfigNetworkArray{}
+		for _, n := range cfg.Networks {
+			networks = append(networks, &dns.ManagedZonePrivateVisibilityConfigNetworkArgs{
+				NetworkUrl: pulumi.String(n),
+			})
+		}
+		args.PrivateVisibilityConfig = &dns.ManagedZonePrivateVisibilityConfigArgs{
+			Networks: networks,
+		}
+	}
+
+	if len(cfg.ForwardingTargets) > 0 {
+		targets := dns.ManagedZoneForwardingConfigTargetNameServerArray{}
+		for _, ip := range cfg.ForwardingTargets {
+			targets = append(targets, &dns.ManagedZoneForwardingConfigTargetNameServerArgs{
+				Ipv4Address: pulumi.String(ip),
+			})
+		}
+		args.ForwardingConfig = &dns.ManagedZoneForwardingConfigArgs{
+			TargetNameServers: targets,
+		}
+	}
+
+	if cfg.PeeringNetwork != "" {
+		args.PeeringConfig = &dns.ManagedZonePeeringConfigArgs{
+			TargetNetwork: &dns.ManagedZonePeeringConfigTargetNetworkArgs{
+				NetworkUrl: pulumi.String(cfg.PeeringNetwork),
+			},
+		}
+	}
+
+	if cfg.DNSSEC {
+		// NSEC3 keeps the zone from being walked for its names.
+		args.DnssecConfig = &dns.ManagedZoneDnssecConfigArgs{
+			State:        pulumi.String("on"),
+			NonExistence: pulumi.String("nsec3"),
+		}
+	}
+
+	zone, err := dns.NewManagedZone(ctx, "dns-zone", args, pulumi.Protect(true), pulumi.RetainOnDelete(true))
+	if err != nil {
+		return nil, fmt.Errorf("creating managed zone %s: %w", cfg.Name, err)
+	}
+	return zone, nil
+}
+
+// LookupZone reads an existing managed zone the stack does not manage.
+func LookupZone(ctx *pulumi.Context, name string) (*Zone, error) {
+	zone, err := dns.LookupManagedZone(ctx, &dns.LookupManagedZoneArgs{Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("looking up managed zone %s: %w", name, err)
+	}
+	return &Zone{
+		Name:        zone.Name,
+		Domain:      strings.TrimSuffix(zone.DnsName, "."),
+		Visibility:  zone.Visibility,
+		NameServers: pulumi.ToStringArray(zone.NameServers).ToStringArrayOutput(),
+		DSRecords:   pulumi.StringArray{}.ToStringArrayOutput(),
+	}, nil
+}
+
+// dsRecords returns the DS records of the zone's active key-signing keys.
+func dsRecords(ctx *pulumi.Context, zone pulumi.StringOutput) pulumi.StringArrayOutput {
+	keys := dns.GetKeysOutput(ctx, dns.GetKeysOutputArgs{ManagedZone: zone})
+	return keys.KeySigningKeys().ApplyT(func(ksks []dns.GetKeysKeySigningKey) []string {
+		var records []string
+		for _, k := range ksks {
+			if k.IsActive && k.DsRecord != "" {
+				records = append(records, k.DsRecord)
+			}
+		}
+		return records
+	}).(pulumi.StringArrayOutput)
+}
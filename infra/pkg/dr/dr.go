@@ -0,0 +1,177 @@
+// Package dr provisions a cold standby of the Antarctica VM on a second
+// Proxmox node, fed by storage replication of the data disk.
+//
+// The standby is a clone of the same template, sized like the primary and
+// left stopped. A Proxmox replication job copies the primary's data disk
+// (the boot disk is excluded) to the standby's node every few minutes, so
+// failing over means attaching that replica to the standby, starting it
+// and moving the service records to its address. The steps are exported
+// with the stack outputs, and `antarctica-infra failover` moves the
+// records.
+//
+// Proxmox replicates between the nodes of one cluster only, onto a ZFS
+// pool with the same storage ID on both. A standby on another cluster
+// (e.g. a second site) is provisioned there through that cluster's
+// provider but not replicated to: failing over to it means restoring the
+// data disk from backups, as its failover steps say.
+package dr
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi"
+	"github.com/nerdsrun/antarctica/infra/pkg/vm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// DefaultSchedule replicates every 15 minutes, which bounds the data lost
+// in a failover.
+const DefaultSchedule = "*/15"
+
+// Config describes the standby.
+type Config struct {
+	// Node the standby lives on. Empty disables DR.
+	Node string
+	// Cluster of Node (see package cluster). Empty means the primary's.
+	Cluster string
+	// Template and storage pool on Cluster when it is not the primary's;
+	// zero values keep the primary's.
+	TemplateVMID int
+	TemplateNode string
+	StoragePool  string
+	// VM ID of the standby.
+	VMID int
+	// Static IP of the standby in CIDR notation. A stopped VM reports no
+	// address, so DHCP is not an option.
+	IPAddress string
+	// Replication schedule (a Proxmox calendar event).
+	Schedule string
+	// Replication bandwidth limit in MB/s; 0 is unlimited.
+	RateMBps float64
+	// The standby has taken over: service records and the exported vm_ip
+	// point at it, and replication is left alone.
+	Active bool
+}
+
+// Enabled reports whether a standby is configured.
+func (c Config) Enabled() bool {
+	return c.Node != ""
+}
+
+// CrossCluster reports whether the standby lives on another cluster than
+// primary, which rules out replication.
+func (c Config) CrossCluster(primary vm.Config) bool {
+	return c.Cluster != "" && c.Cluster != primary.Cluster
+}
+
+// Validate checks the standby against the primary.
+func (c Config) Validate(primary vm.Config) error {
+	// Node names and VM IDs only have to differ within one cluster.
+	sameCluster := !c.CrossCluster(primary)
+	switch {
+	case sameCluster && c.Node == primary.Node:
+		return fmt.Errorf("the standby must be on another node than %s", primary.Node)
+	case sameCluster && c.VMID == primary.VMID:
+		return fmt.Errorf("the standby needs a VM ID other than %d", primary.VMID)
+	case c.IPAddress == "":
+		return fmt.Errorf("the standby needs a static IP address")
+	}
+	return nil
+}
+
+// Standby derives the standby's VM config from the primary's.
+func Standby(primary vm.Config, cfg Config) vm.Config {
+	standby := primary
+	standby.Node = cfg.Node
+	if cfg.CrossCluster(primary) {
+		standby.Cluster = cfg.Cluster
+		if cfg.TemplateVMID != 0 {
+			standby.TemplateVMID = cfg.TemplateVMID
+		}
+		if cfg.TemplateNode != "" {
+			standby.TemplateNode = cfg.TemplateNode
+		}
+		if cfg.StoragePool != "" {
+			standby.StoragePool = cfg.StoragePool
+		}
+	}
+	standby.VMID = cfg.VMID
+	standby.Hostname = primary.Hostname + "-dr"
+	standby.IPAddress = cfg.IPAddress
+	// The primary's IPv6 address stays with the primary.
+	standby.IPv6Address, standby.IPv6Gateway = "", ""
+	standby.ReplicateDataOnly = false
+	standby.Stopped = true
+	// Passthrough devices belong to the primary's host.
+	standby.PCIDevices = nil
+	standby.USBDevices = nil
+	return standby
+}
+
+// JobID is the ID of the replication job of a VM.
+func JobID(vmid int) string {
+	return fmt.Sprintf("%d-0", vmid)
+}
+
+// Job is the replication job copying primary's data disk to the standby's
+// node.
+func Job(primary vm.Config, cfg Config) pveapi.ReplicationJob {
+	schedule := cfg.Schedule
+	if schedule == "" {
+		schedule = DefaultSchedule
+	}
+	return pveapi.ReplicationJob{
+		ID:       JobID(primary.VMID),
+		Guest:    pveapi.Int(primary.VMID),
+		Target:   cfg.Node,
+		Schedule: schedule,
+		Rate:     cfg.RateMBps,
+		Comment:  fmt.Sprintf("Antarctica data disk for standby %d", cfg.VMID),
+	}
+}
+
+// Replicate ensures the replication job once the primary VM exists and
+// returns its ID. The provider has no replication resource, so the job
+// is managed through the API during `pulumi up` only; removing DR does
+// not delete it.
+func Replicate(ctx *pulumi.Context, client *pveapi.Client, primary *vm.Result, job pveapi.ReplicationJob) pulumi.StringOutput {
+	return primary.VM.ID().ApplyT(func(_ pulumi.ID) (string, error) {
+		if !ctx.DryRun() {
+			if err := client.EnsureReplicationJob(context.Background(), job); err != nil {
+				return "", fmt.Errorf("ensuring replication job %s: %w", job.ID, err)
+			}
+		}
+		return job.ID, nil
+	}).(pulumi.StringOutput)
+}
+
+// Steps lists the failover procedure for the stack outputs.
+func Steps(stack string, primary, standby vm.Config) []string {
+	var data []string
+	if standby.Cluster != primary.Cluster {
+		data = []string{
+			fmt.Sprintf("Make sure VM %d on %s stays down; the standby on cluster %s resumes from its latest backup.",
+				primary.VMID, primary.Node, standby.Cluster),
+			fmt.Sprintf("Restore the data disk (scsi1) of VM %d's latest backup to %s on %s, e.g. by restoring the backup as a spare VM.",
+				primary.VMID, standby.StoragePool, standby.Node),
+			fmt.Sprintf("Attach the restored volume to the standby in place of its own data disk: `qm set %d --scsi1 %s:<volume>`.",
+				standby.VMID, standby.StoragePool),
+		}
+	} else {
+		data = []string{
+			fmt.Sprintf("Make sure VM %d on %s stays down; its last replication is the state the standby resumes from.",
+				primary.VMID, primary.Node),
+			fmt.Sprintf("On %s, find the replicated data disk with `pvesm list %s --vmid %d` (the volume of scsi1).",
+				standby.Node, standby.StoragePool, primary.VMID),
+			fmt.Sprintf("Attach it to the standby in place of its own data disk: `qm set %d --scsi1 %s:<volume>`.",
+				standby.VMID, standby.StoragePool),
+		}
+	}
+	return append(data,
+		fmt.Sprintf("Start the standby: `qm start %d`.", standby.VMID),
+		fmt.Sprintf("Move the service records and vm_ip to %s: `antarctica-infra failover -stack %s`.",
+			vm.StripCIDR(standby.IPAddress), stack),
+		"Reconverge the standby: `mise run deploy:configure`.",
+	)
+}
@@ -0,0 +1,102 @@
+package foundation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/dns"
+	"github.com/nerdsrun/antarctica/infra/pkg/vm"
+)
+
+// DefaultImageURL is the cloud image the template is built from.
+const DefaultImageURL = "https://cloud.debian.org/images/cloud/bookworm/latest/debian-12-genericcloud-amd64.qcow2"
+
+// Config holds the parsed foundation:* stack config.
+type Config struct {
+	// Managed zone for service records.
+	Zone dns.ZoneConfig
+	// Shared network settings handed to the Antarctica stacks.
+	NetworkBridge string
+	Gateway       string
+	Nameserver    string
+	// Cloud-init template.
+	Template vm.TemplateConfig
+	// Build the template; false uses an existing one with Template's node,
+	// VM ID and name, and leaves it alone.
+	CreateTemplate bool
+}
+
+// LoadConfig parses the foundation:* config. get returns the raw value of
+// a key within Namespace, or "" when unset.
+func LoadConfig(get func(key string) string) (*Config, error) {
+	or := func(key, defaultVal string) string {
+		if v := get(key); v != "" {
+			return v
+		}
+		return defaultVal
+	}
+
+	node := get("proxmox_node")
+	if node == "" {
+		return nil, fmt.Errorf("missing required configuration variable '%s:proxmox_node'", Namespace)
+	}
+	templateVMID, err := strconv.Atoi(or("template_vm_id", "9000"))
+	if err != nil {
+		return nil, fmt.Errorf("reading template_vm_id: %w", err)
+	}
+
+	createZone, err := strconv.ParseBool(or("create_dns_zone", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("reading create_dns_zone: %w", err)
+	}
+	createTemplate, err := strconv.ParseBool(or("create_template", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("reading create_template: %w", err)
+	}
+	dnssec, err := strconv.ParseBool(or("dnssec", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("reading dnssec: %w", err)
+	}
+	var networks, forwarders []string
+	for key, out := range map[string]*[]string{
+		"private_networks":   &networks,
+		"forwarding_targets": &forwarders,
+	} {
+		if raw := get(key); raw != "" {
+			if err := json.Unmarshal([]byte(raw), out); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", key, err)
+			}
+		}
+	}
+
+	bridge := or("network_bridge", "vmbr0")
+	storagePool := or("storage_pool", "local-lvm")
+	return &Config{
+		Zone: dns.ZoneConfig{
+			Name:              get("dns_zone"),
+			Domain:            get("dns_domain"),
+			Description:       or("dns_zone_description", "Antarctica service records"),
+			Create:            createZone,
+			Visibility:        or("dns_visibility", dns.VisibilityPrivate),
+			Networks:          networks,
+			DNSSEC:            dnssec,
+			ForwardingTargets: forwarders,
+			PeeringNetwork:    get("peering_network"),
+		},
+		NetworkBridge: bridge,
+		Gateway:       get("gateway"),
+		Nameserver:    get("nameserver"),
+		Template: vm.TemplateConfig{
+			Node:           node,
+			VMID:           templateVMID,
+			Name:           or("template_name", "debian-12-cloudinit"),
+			ImageURL:       or("image_url", DefaultImageURL),
+			ImageChecksum:  get("image_checksum"),
+			ImageDatastore: or("image_datastore", "local"),
+			StoragePool:    storagePool,
+			NetworkBridge:  bridge,
+		},
+		CreateTemplate: createTemplate,
+	}, nil
+}
@@ -0,0 +1,129 @@
+// Package foundation defines the shared foundation stack and how the
+// Antarctica stacks read it.
+//
+// The foundation project (infra/foundation) owns what every environment
+// shares: the Cloud DNS managed zone, the cluster-wide Proxmox firewall
+// security groups and the cloud-init template VMs are cloned from. It
+// exports them as one "foundation" object shaped by Outputs. An Antarctica
+// stack names its foundation stack in antarctica:foundation_stack and reads
+// the object through a StackReference; the values become defaults for the
+// matching antarctica:* config keys, so a new environment (staging, prod)
+// inherits the zone, network and template instead of copying them.
+package foundation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Project is the Pulumi project name of the foundation program.
+const Project = "antarctica-foundation"
+
+// Namespace is the config namespace of the foundation program.
+const Namespace = "foundation"
+
+// Key is the name of the stack output holding Outputs.
+const Key = "foundation"
+
+// SchemaVersion is the version of the Outputs shape. Bump it on any change
+// to the fields below.
+const SchemaVersion = 2
+
+// Outputs is the "foundation" stack output.
+type Outputs struct {
+	SchemaVersion int `pulumi:"schema_version" json:"schema_version"`
+
+	// Cloud DNS managed zone and the domain it serves (no trailing dot).
+	DNSZone   string `pulumi:"dns_zone" json:"dns_zone"`
+	DNSDomain string `pulumi:"dns_domain" json:"dns_domain"`
+	// "private" or "public".
+	DNSVisibility string `pulumi:"dns_visibility" json:"dns_visibility"`
+	// Name servers to delegate a public zone to, and the DS records to
+	// register with the parent domain when it is signed.
+	DNSNameServers []string `pulumi:"dns_name_servers" json:"dns_name_servers"`
+	DNSDSRecords   []string `pulumi:"dns_ds_records" json:"dns_ds_records"`
+
+	// Shared network settings for static addressing.
+	NetworkBridge string `pulumi:"network_bridge" json:"network_bridge"`
+	Gateway       string `pulumi:"gateway" json:"gateway"`
+	Nameserver    string `pulumi:"nameserver" json:"nameserver"`
+
+	// Cloud-init template to clone, and the node holding it.
+	TemplateNode string `pulumi:"template_node" json:"template_node"`
+	TemplateVMID int    `pulumi:"template_vm_id" json:"template_vm_id"`
+	TemplateName string `pulumi:"template_name" json:"template_name"`
+
+	// Cluster-wide Proxmox firewall security groups.
+	SecurityGroups []string `pulumi:"security_groups" json:"security_groups"`
+}
+
+// ConfigDefaults maps the outputs onto the antarctica:* config keys they
+// provide defaults for.
+func (o Outputs) ConfigDefaults() map[string]string {
+	return map[string]string{
+		"gcp_dns_zone":        o.DNSZone,
+		"dns_domain":          o.DNSDomain,
+		"network_bridge":      o.NetworkBridge,
+		"gateway":             o.Gateway,
+		"nameserver":          o.Nameserver,
+		"template_node":       o.TemplateNode,
+		"template_vm_id":      strconv.Itoa(o.TemplateVMID),
+		"cloud_init_template": o.TemplateName,
+	}
+}
+
+// Decode converts the raw "foundation" output value into Outputs,
+// rejecting other schema versions.
+func Decode(value interface{}) (*Outputs, error) {
+	if value == nil {
+		return nil, fmt.Errorf("stack has no %q output; run `mise run deploy:foundation` first", Key)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %q output: %w", Key, err)
+	}
+
+	var out Outputs
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decoding %q output: %w", Key, err)
+	}
+	if out.SchemaVersion != SchemaVersion {
+		return nil, fmt.Errorf("%q output has schema_version %d, this program understands %d; "+
+			"update the foundation stack or this checkout", Key, out.SchemaVersion, SchemaVersion)
+	}
+	return &out, nil
+}
+
+// StackName qualifies a foundation stack name. A bare name ("dev") refers
+// to the foundation project in org; "org/project/stack" is used as is.
+func StackName(org, name string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	return fmt.Sprintf("%s/%s/%s", org, Project, name)
+}
+
+// Read reads the foundation outputs of stack through a StackReference.
+func Read(ctx *pulumi.Context, stack string) (*Outputs, error) {
+	name := StackName(ctx.Organization(), stack)
+	ref, err := pulumi.NewStackReference(ctx, "foundation", &pulumi.StackReferenceArgs{
+		Name: pulumi.String(name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("referencing foundation stack %s: %w", name, err)
+	}
+
+	details, err := ref.GetOutputDetails(Key)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s output of %s: %w", Key, name, err)
+	}
+	out, err := Decode(details.Value)
+	if err != nil {
+		return nil, fmt.Errorf("foundation stack %s: %w", name, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,124 @@
+// Package health defines the Antarctica service health checks and runs them
+// concurrently with per-check timeouts.
+//
+// Checks are transport-agnostic: each service describes a command to run on
+// the VM and, when Caddy exposes it, a URL path to fetch over HTTPS. The
+// caller supplies the probe that executes one or the other.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of a single check.
+type Status string
+
+const (
+	Healthy   Status = "healthy"
+	Unhealthy Status = "unhealthy"
+	// Skipped checks cannot run over the chosen transport (e.g. PostgreSQL
+	// over HTTPS) and do not count as failures.
+	Skipped Status = "skipped"
+)
+
+// Service is a health-checked Antarctica service.
+type Service struct {
+	// Short name shown in reports.
+	Name string
+	// Command run on the VM over SSH; healthy when it exits 0.
+	Command string
+	// Subdomain Caddy serves the service on. Empty when not exposed.
+	Subdomain string
+	// Path fetched through Caddy over HTTPS; healthy on a 2xx response.
+	Path string
+}
+
+// Services lists every check, mirroring the former ops:health script.
+var Services = []Service{
+	{
+		Name:      "forgejo",
+		Command:   "curl -sf http://127.0.0.1:3000/api/v1/version",
+		Subdomain: "forgejo",
+		Path:      "/api/v1/version",
+	},
+	{
+		Name:      "woodpecker",
+		Command:   "curl -sf http://127.0.0.1:3040/api/info",
+		Subdomain: "woodpecker",
+		Path:      "/api/info",
+	},
+	{
+		Name:    "postgresql-woodpecker",
+		Command: "sudo podman exec postgresql pg_isready -U woodpecker",
+	},
+	{
+		Name:    "postgresql-forgejo",
+		Command: "sudo podman exec forgejo-postgresql pg_isready -U forgejo",
+	},
+	{
+		Name:    "caddy",
+		Command: "sudo systemctl is-active caddy",
+	},
+}
+
+// Probe executes one service check. It returns a short detail (response
+// body, command output) and an error when the service is unhealthy, or
+// ErrSkipped when the check does not apply.
+type Probe func(ctx context.Context, svc Service) (string, error)
+
+// ErrSkipped is returned by a Probe for checks it cannot perform.
+var ErrSkipped = errors.New("not checkable over this transport")
+
+// Result is the outcome of one check.
+type Result struct {
+	Name     string        `json:"name"`
+	Status   Status        `json:"status"`
+	Detail   string        `json:"detail,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Run checks every service concurrently, bounding each with timeout.
+// Results keep the order of services.
+func Run(ctx context.Context, services []Service, timeout time.Duration, probe Probe) []Result {
+	results := make([]Result, len(services))
+
+	var wg sync.WaitGroup
+	for i, svc := range services {
+		wg.Add(1)
+		go func(i int, svc Service) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			detail, err := probe(checkCtx, svc)
+			res := Result{Name: svc.Name, Status: Healthy, Detail: detail, Duration: time.Since(start)}
+			switch {
+			case errors.Is(err, ErrSkipped):
+				res.Status = Skipped
+				res.Detail = err.Error()
+			case err != nil:
+				res.Status = Unhealthy
+				res.Detail = err.Error()
+			}
+			results[i] = res
+		}(i, svc)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// AllHealthy reports whether no check failed. Skipped checks are ignored.
+func AllHealthy(results []Result) bool {
+	for _, r := range results {
+		if r.Status == Unhealthy {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+// Package hostkeys pins the SSH host keys of the Antarctica VM.
+//
+// Without pinning, every rebuild gives the VM fresh host keys and clients
+// fall back to StrictHostKeyChecking=accept-new, which trusts a rebuilt VM
+// and a man in the middle alike. Instead, keys are generated once per
+// hostname, stored in 1Password, and handed to cloud-init as vendor data,
+// so the VM always presents the same identity. The public halves are
+// exported as stack outputs for known_hosts.
+package hostkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/secrets"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultVault is the 1Password vault host keys are stored in.
+const DefaultVault = "Infrastructure"
+
+// rsaBits matches the ssh-keygen default.
+const rsaBits = 3072
+
+// Types lists the generated key types, named as cloud-init's ssh_keys
+// module expects ("<type>_private", "<type>_public").
+var Types = []string{"ed25519", "rsa"}
+
+// Key is one host key pair.
+type Key struct {
+	// Key type from Types.
+	Type string
+	// Private key in OpenSSH PEM format.
+	PrivatePEM string
+	// Public key.
+	Public ssh.PublicKey
+}
+
+// AuthorizedKey returns the public key as a single "type base64" line.
+func (k Key) AuthorizedKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k.Public)))
+}
+
+// Set is the full set of host keys for one VM.
+type Set struct {
+	Keys []Key
+}
+
+// ItemTitle is the 1Password item holding the host keys of hostname. Each
+// VM (including preview environments) gets its own item.
+func ItemTitle(hostname string) string {
+	return "antarctica_ssh_host_keys_" + hostname
+}
+
+// Ensure returns the host keys of hostname from 1Password, generating and
+// storing them on first use. During a preview, missing keys are generated
+// but not stored; the following `pulumi up` stores its own.
+func Ensure(ctx *pulumi.Context, vault, hostname string) (*Set, error) {
+	if !secrets.CLIAvailable() {
+		return nil, fmt.Errorf("pinning SSH host keys needs the 1Password CLI (op) in PATH; " +
+			"set antarctica:pin_host_keys to false to provision without pinned keys")
+	}
+
+	title := ItemTitle(hostname)
+	fields, err := secrets.ReadFields(vault, title)
+	switch {
+	case err == nil:
+		set, err := Parse(fields)
+		if err != nil {
+			return nil, fmt.Errorf("1Password item %s/%s: %w", vault, title, err)
+		}
+		return set, nil
+	case !errors.Is(err, secrets.ErrItemNotFound):
+		return nil, err
+	}
+
+	set, err := Generate(hostname)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.DryRun() {
+		ctx.Log.Info(fmt.Sprintf("SSH host keys for %s will be generated and stored in 1Password item %s/%s",
+			hostname, vault, title), nil)
+		return set, nil
+	}
+	if err := secrets.CreateItem(vault, title, set.fields()); err != nil {
+		return nil, err
+	}
+	ctx.Log.Info(fmt.Sprintf("Generated SSH host keys for %s and stored them in 1Password item %s/%s",
+		hostname, vault, title), nil)
+	return set, nil
+}
+
+// Generate creates a new key of every type in Types.
+func Generate(hostname string) (*Set, error) {
+	set := &Set{}
+	for _, typ := range Types {
+		var priv crypto.Signer
+		var err error
+		switch typ {
+		case "ed25519":
+			_, priv, err = ed25519.GenerateKey(rand.Reader)
+		case "rsa":
+			priv, err = rsa.GenerateKey(rand.Reader, rsaBits)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("generating %s host key: %w", typ, err)
+		}
+
+		block, err := ssh.MarshalPrivateKey(priv, "root@"+hostname)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s host key: %w", typ, err)
+		}
+		pub, err := ssh.NewPublicKey(priv.Public())
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s host key: %w", typ, err)
+		}
+		set.Keys = append(set.Keys, Key{Type: typ, PrivatePEM: string(pem.EncodeToMemory(block)), Public: pub})
+	}
+	return set, nil
+}
+
+// Parse rebuilds a Set from stored item fields ("<type>_private"). Public
+// keys are derived from the private keys.
+func Parse(fields map[string]string) (*Set, error) {
+	set := &Set{}
+	for _, typ := range Types {
+		privatePEM := fields[typ+"_private"]
+		if privatePEM == "" {
+			return nil, fmt.Errorf("missing field %s_private", typ)
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(privatePEM))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s host key: %w", typ, err)
+		}
+		set.Keys = append(set.Keys, Key{Type: typ, PrivatePEM: privatePEM, Public: signer.PublicKey()})
+	}
+	return set, nil
+}
+
+// fields is the inverse of Parse.
+func (s *Set) fields() map[string]string {
+	fields := make(map[string]string, len(s.Keys))
+	for _, k := range s.Keys {
+		fields[k.Type+"_private"] = k.PrivatePEM
+	}
+	return fields
+}
+
+// PublicKeys returns every public key as an authorized_keys line.
+func (s *Set) PublicKeys() []string {
+	keys := make([]string, len(s.Keys))
+	for i, k := range s.Keys {
+		keys[i] = k.AuthorizedKey()
+	}
+	return keys
+}
+
+// KnownHosts returns known_hosts lines matching every key for the given
+// addresses (IPs or names) on port.
+func (s *Set) KnownHosts(addresses []string, port int) string {
+	var hosts []string
+	for _, addr := range addresses {
+		if addr != "" {
+			hosts = append(hosts, net.JoinHostPort(addr, strconv.Itoa(port)))
+		}
+	}
+	var lines []string
+	for _, k := range s.Keys {
+		lines = append(lines, knownhosts.Line(hosts, k.Public))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// CloudConfig returns cloud-init vendor data that replaces the image's
+// host keys with the pinned ones and stops cloud-init generating others.
+func (s *Set) CloudConfig() string {
+	var b strings.Builder
+	b.WriteString("#cloud-config\n")
+	b.WriteString("ssh_deletekeys: true\n")
+	b.WriteString("ssh_genkeytypes: []\n")
+	b.WriteString("ssh_keys:\n")
+	for _, k := range s.Keys {
+		fmt.Fprintf(&b, "  %s_private: |\n", k.Type)
+		for _, line := range strings.Split(strings.TrimSpace(k.PrivatePEM), "\n") {
+			fmt.Fprintf(&b, "    %s\n", line)
+		}
+		fmt.Fprintf(&b, "  %s_public: %s\n", k.Type, k.AuthorizedKey())
+	}
+	return b.String()
+}
@@ -0,0 +1,296 @@
+// Package mail publishes the DNS records that let Forgejo's notification
+// mail pass SPF, DKIM and DMARC checks.
+//
+// Without them, receivers cannot tell Forgejo's mail from a forgery of
+// the domain and file it as spam. A DKIM key pair is generated once per
+// hostname and stored in 1Password, and three TXT records are created in
+// a public zone: SPF listing the hosts allowed to send for the domain, the
+// DKIM public key under its selector, and the DMARC policy. The records
+// live in the public zone because receivers never see the private one.
+//
+// Forgejo hands its mail to an SMTP relay and cannot sign it itself. The
+// relay must DKIM-sign with the stored key and send from an address SPF
+// lists; the values it needs are exported with the stack outputs.
+package mail
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/dns"
+	"github.com/nerdsrun/antarctica/infra/pkg/secrets"
+	gcpdns "github.com/pulumi/pulumi-gcp/sdk/v8/go/gcp/dns"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Defaults for the optional settings.
+const (
+	DefaultSelector = "antarctica"
+	DefaultDMARC    = "quarantine"
+)
+
+// KeyField is the 1Password field holding the DKIM private key.
+const KeyField = "dkim_private_key"
+
+// rsaBits is the DKIM key size; 1024-bit keys are rejected by some
+// receivers, and 2048 still fits a TXT record.
+const rsaBits = 2048
+
+// DMARC policies.
+var dmarcPolicies = []string{"none", "quarantine", "reject"}
+
+// Config describes the mail records of one VM.
+type Config struct {
+	// Public managed zone the records are created in.
+	Zone string
+	// Domain of the sender address; must be within the zone.
+	Domain string
+	// Sender address Forgejo uses (e.g. "Forgejo <noreply@dev.nerds.run>").
+	// Empty uses noreply@Domain.
+	From string
+	// SPF mechanisms of the hosts allowed to send for Domain, e.g.
+	// "ip4:203.0.113.7 include:_spf.example.net". The VM's own address is
+	// private, so it cannot be derived.
+	SPF string
+	// DKIM selector the public key is published under.
+	Selector string
+	// DMARC policy for mail failing both checks: none, quarantine or reject.
+	DMARCPolicy string
+	// Address aggregate DMARC reports are mailed to; empty asks for none.
+	DMARCReports string
+	// SMTP relay Forgejo hands mail to, as host:port.
+	Relay string
+	// VM hostname; names the 1Password item.
+	Hostname string
+	// 1Password vault the DKIM key is stored in.
+	Vault string
+	// Drop delete/replace protection on the records (see vm.Config.AllowReplace).
+	AllowReplace bool
+}
+
+// Enabled reports whether mail records are configured.
+func (c Config) Enabled() bool {
+	return c.Zone != ""
+}
+
+// Validate checks the settings the records are built from.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Domain == "" {
+		errs = append(errs, errors.New("mail records need a sender domain"))
+	}
+	if c.SPF == "" {
+		errs = append(errs, errors.New("mail records need the SPF mechanisms of the sending hosts"))
+	}
+	for _, m := range strings.Fields(c.SPF) {
+		if m == "v=spf1" || strings.TrimLeft(m, "+-~?") == "all" {
+			errs = append(errs, fmt.Errorf("SPF %q: list only the mechanisms; the version and -all are added", c.SPF))
+			break
+		}
+	}
+	policy := c.DMARCPolicy
+	if policy == "" {
+		policy = DefaultDMARC
+	}
+	valid := false
+	for _, p := range dmarcPolicies {
+		valid = valid || p == policy
+	}
+	if !valid {
+		errs = append(errs, fmt.Errorf("DMARC policy %q is not one of %v", policy, dmarcPolicies))
+	}
+	if c.Relay == "" {
+		errs = append(errs, errors.New("mail records need the SMTP relay that signs and sends the mail"))
+	}
+	return errors.Join(errs...)
+}
+
+// Settings are the values the Forgejo mailer and its relay need.
+type Settings struct {
+	// Sender address.
+	From string
+	// SMTP relay as host:port.
+	Relay string
+	// DKIM selector and signing domain.
+	Selector string
+	Domain   string
+	// op:// reference of the DKIM private key (PEM).
+	KeyReference string
+}
+
+// ItemTitle is the 1Password item holding the DKIM key of hostname.
+func ItemTitle(hostname string) string {
+	return "antarctica_dkim_" + hostname
+}
+
+// Reference is the op:// reference of the key in an item.
+func Reference(vault, title string) string {
+	return fmt.Sprintf("op://%s/%s/%s", vault, title, KeyField)
+}
+
+// Provision ensures the DKIM key and creates the SPF, DKIM and DMARC
+// records.
+func Provision(ctx *pulumi.Context, cfg Config) (*Settings, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mail config: %w", err)
+	}
+	if cfg.Selector == "" {
+		cfg.Selector = DefaultSelector
+	}
+	if cfg.DMARCPolicy == "" {
+		cfg.DMARCPolicy = DefaultDMARC
+	}
+	if cfg.From == "" {
+		cfg.From = "noreply@" + cfg.Domain
+	}
+
+	zone, err := dns.LookupZone(ctx, cfg.Zone)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Domain != zone.Domain && !strings.HasSuffix(cfg.Domain, "."+zone.Domain) {
+		return nil, fmt.Errorf("mail domain %s is not within managed zone %s (%s)", cfg.Domain, cfg.Zone, zone.Domain)
+	}
+	if zone.Visibility == dns.VisibilityPrivate {
+		return nil, fmt.Errorf("managed zone %s is private; mail receivers can only see a public zone", cfg.Zone)
+	}
+
+	key, err := ensureKey(ctx, cfg.Vault, cfg.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("encoding DKIM public key: %w", err)
+	}
+
+	records := []struct {
+		resource, name, value string
+	}{
+		{"mail-spf", cfg.Domain, SPFRecord(cfg.SPF)},
+		{"mail-dkim", cfg.Selector + "._domainkey." + cfg.Domain, DKIMRecord(public)},
+		{"mail-dmarc", "_dmarc." + cfg.Domain, DMARCRecord(cfg.DMARCPolicy, cfg.DMARCReports)},
+	}
+	var opts []pulumi.ResourceOption
+	if !cfg.AllowReplace {
+		opts = append(opts, pulumi.Protect(true), pulumi.RetainOnDelete(true))
+	}
+	for _, r := range records {
+		_, err := gcpdns.NewRecordSet(ctx, r.resource, &gcpdns.RecordSetArgs{
+			ManagedZone: pulumi.String(cfg.Zone),
+			Name:        pulumi.String(r.name + "."),
+			Type:        pulumi.String("TXT"),
+			Ttl:         pulumi.Int(dns.DefaultTTL),
+			Rrdatas:     pulumi.StringArray{pulumi.String(TXT(r.value))},
+		}, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("creating TXT record %s: %w", r.name, err)
+		}
+		ctx.Log.Info(fmt.Sprintf("DNS record: %s. TXT %s", r.name, r.value), nil)
+	}
+
+	return &Settings{
+		From:         cfg.From,
+		Relay:        cfg.Relay,
+		Selector:     cfg.Selector,
+		Domain:       cfg.Domain,
+		KeyReference: Reference(cfg.Vault, ItemTitle(cfg.Hostname)),
+	}, nil
+}
+
+// ensureKey returns the DKIM key of hostname from 1Password, generating
+// and storing it on first use. During a preview, a missing key is
+// generated but not stored; the following `pulumi up` stores its own.
+func ensureKey(ctx *pulumi.Context, vault, hostname string) (*rsa.PrivateKey, error) {
+	if !secrets.CLIAvailable() {
+		return nil, fmt.Errorf("storing the DKIM key needs the 1Password CLI (op) in PATH; " +
+			"unset antarctica:mail_dns_zone to skip the mail records")
+	}
+
+	// Only an item op reports as missing gets a new key. A new key means a
+	// new DKIM record, which breaks the signatures of mail in flight, so
+	// any other op failure (e.g. a signed-out session) stops the run.
+	title := ItemTitle(hostname)
+	fields, err := secrets.ReadFields(vault, title)
+	switch {
+	case err == nil:
+		key, err := parseKey(fields[KeyField])
+		if err != nil {
+			return nil, fmt.Errorf("1Password item %s/%s: %w", vault, title, err)
+		}
+		return key, nil
+	case !errors.Is(err, secrets.ErrItemNotFound):
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, rsaBits)
+	if err != nil {
+		return nil, fmt.Errorf("generating DKIM key: %w", err)
+	}
+	if ctx.DryRun() {
+		ctx.Log.Info(fmt.Sprintf("A DKIM key for %s will be generated and stored in 1Password item %s/%s",
+			hostname, vault, title), nil)
+		return key, nil
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := secrets.CreateItem(vault, title, map[string]string{KeyField: string(pem.EncodeToMemory(block))}); err != nil {
+		return nil, err
+	}
+	ctx.Log.Info(fmt.Sprintf("Generated a DKIM key for %s and stored it in 1Password item %s/%s",
+		hostname, vault, title), nil)
+	return key, nil
+}
+
+// parseKey decodes a PEM-encoded RSA private key.
+func parseKey(privatePEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privatePEM))
+	if block == nil {
+		return nil, fmt.Errorf("field %s holds no PEM key", KeyField)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing DKIM key: %w", err)
+	}
+	return key, nil
+}
+
+// SPFRecord allows the given mechanisms and fails everything else.
+func SPFRecord(mechanisms string) string {
+	return "v=spf1 " + strings.Join(strings.Fields(mechanisms), " ") + " -all"
+}
+
+// DKIMRecord publishes a DER-encoded RSA public key.
+func DKIMRecord(publicDER []byte) string {
+	return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(publicDER)
+}
+
+// DMARCRecord asks receivers to apply policy to mail failing alignment,
+// and to mail aggregate reports to reports when set.
+func DMARCRecord(policy, reports string) string {
+	record := "v=DMARC1; p=" + policy
+	if reports != "" {
+		record += "; rua=mailto:" + reports
+	}
+	return record
+}
+
+// TXT quotes a value as Cloud DNS expects TXT data, split into strings of
+// at most 255 characters (a DKIM key is longer).
+func TXT(value string) string {
+	var parts []string
+	for len(value) > 255 {
+		parts = append(parts, value[:255])
+		value = value[255:]
+	}
+	parts = append(parts, value)
+	for i, p := range parts {
+		parts[i] = `"` + p + `"`
+	}
+	return strings.Join(parts, " ")
+}
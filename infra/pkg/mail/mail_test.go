@@ -0,0 +1,79 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTXT(t *testing.T) {
+	long := strings.Repeat("a", 255)
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"short", "v=spf1 -all", `"v=spf1 -all"`},
+		{"empty", "", `""`},
+		{"exactly 255", long, `"` + long + `"`},
+		{"256", long + "b", `"` + long + `" "b"`},
+		{"two full chunks", long + long, `"` + long + `" "` + long + `"`},
+	}
+	for _, tt := range tests {
+		if got := TXT(tt.value); got != tt.want {
+			t.Errorf("%s: TXT() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDKIMRecordSplitsIntoValidStrings(t *testing.T) {
+	// A 2048-bit key is about 400 characters of base64.
+	record := DKIMRecord(make([]byte, 294))
+	txt := TXT(record)
+	parts := strings.Split(strings.Trim(txt, `"`), `" "`)
+	if len(parts) != 2 {
+		t.Fatalf("TXT(DKIM record) has %d strings, want 2: %s", len(parts), txt)
+	}
+	if strings.Join(parts, "") != record {
+		t.Errorf("strings do not join back to the record")
+	}
+}
+
+func TestSPFRecord(t *testing.T) {
+	if got, want := SPFRecord(" ip4:203.0.113.7   include:_spf.example.net "), "v=spf1 ip4:203.0.113.7 include:_spf.example.net -all"; got != want {
+		t.Errorf("SPFRecord() = %q, want %q", got, want)
+	}
+}
+
+func TestDMARCRecord(t *testing.T) {
+	if got, want := DMARCRecord("reject", ""), "v=DMARC1; p=reject"; got != want {
+		t.Errorf("DMARCRecord() = %q, want %q", got, want)
+	}
+	if got, want := DMARCRecord("none", "dmarc@nerds.run"), "v=DMARC1; p=none; rua=mailto:dmarc@nerds.run"; got != want {
+		t.Errorf("DMARCRecord() = %q, want %q", got, want)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Zone: "public", Domain: "dev.nerds.run", SPF: "ip4:203.0.113.7", Relay: "smtp.example.net:587"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{"no domain", func(c *Config) { c.Domain = "" }},
+		{"no SPF", func(c *Config) { c.SPF = "" }},
+		{"SPF with version", func(c *Config) { c.SPF = "v=spf1 ip4:203.0.113.7" }},
+		{"SPF with -all", func(c *Config) { c.SPF = "ip4:203.0.113.7 -all" }},
+		{"unknown DMARC policy", func(c *Config) { c.DMARCPolicy = "drop" }},
+		{"no relay", func(c *Config) { c.Relay = "" }},
+	}
+	for _, tt := range tests {
+		cfg := valid
+		tt.modify(&cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: Validate() = nil, want an error", tt.name)
+		}
+	}
+}
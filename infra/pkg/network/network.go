@@ -1,31 +1,27 @@
-// Package network exports VM networking details as stack outputs for Ansible.
+// Package network describes VM networking exposed to Ansible.
 //
 // Proxmox-level networking (bridge, VLAN, static IP) is configured in the VM
-// module via cloud-init. This package exposes the resolved values so downstream
-// tooling (Ansible dynamic inventory, CI scripts) can consume them.
+// module via cloud-init. The resolved values are exported in the antarctica
+// stack output (see package outputs) so downstream tooling (Ansible dynamic
+// inventory, CI scripts) can consume them.
 //
-// Firewall rules are NOT managed here. The NixOS config had the firewall
-// force-disabled, and the migration strategy is:
-//   - Proxmox firewall at the hypervisor level (optional, manual)
+// Firewall rules are mostly NOT managed here. The NixOS config had the
+// firewall force-disabled, and the migration strategy is:
+//   - Proxmox firewall at the hypervisor level (optional): cluster-wide
+//     security groups created by the foundation stack, attached to the VM
+//     on request; enabling the firewall on the VM stays manual
 //   - Host-level ufw/nftables configured by Ansible
 package network
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	proxmox "github.com/muhlba91/pulumi-proxmoxve/sdk/v6/go/proxmoxve/network"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-// Config holds network-related values to export.
-type Config struct {
-	// Resolved VM IP address (from QEMU guest agent).
-	IPAddress pulumi.StringOutput
-	// Hostname assigned to the VM.
-	Hostname string
-	// Network bridge the VM is attached to.
-	Bridge string
-	// Gateway address (empty if DHCP).
-	Gateway string
-}
-
 // FirewallPorts lists the TCP ports that should be opened for Antarctica.
 // Ansible uses these to configure ufw/nftables on the host.
 var FirewallPorts = []int{
@@ -37,17 +33,72 @@ var FirewallPorts = []int{
 	9090, // Cockpit
 }
 
-// Export registers network details as Pulumi stack outputs.
-func Export(ctx *pulumi.Context, cfg Config) {
-	ctx.Export("vm_ip", cfg.IPAddress)
-	ctx.Export("vm_hostname", pulumi.String(cfg.Hostname))
-	ctx.Export("network_bridge", pulumi.String(cfg.Bridge))
-	ctx.Export("network_gateway", pulumi.String(cfg.Gateway))
+// SecurityGroup is a named set of inbound TCP ports, shared by every VM
+// that attaches it.
+type SecurityGroup struct {
+	// Proxmox security group name (letters, digits, "-" and "_").
+	Name    string
+	Comment string
+	Ports   []int
+}
+
+// SecurityGroups splits FirewallPorts into the groups the foundation stack
+// creates.
+var SecurityGroups = []SecurityGroup{
+	{Name: "antarctica-ssh", Comment: "OpenSSH and Forgejo Git SSH", Ports: []int{22, 2222}},
+	{Name: "antarctica-web", Comment: "Caddy HTTP and HTTPS", Ports: []int{80, 443}},
+	{Name: "antarctica-admin", Comment: "Docker registry and Cockpit", Ports: []int{5000, 9090}},
+}
 
-	// Export the firewall port list for Ansible to consume.
-	ports := make(pulumi.IntArray, len(FirewallPorts))
-	for i, p := range FirewallPorts {
-		ports[i] = pulumi.Int(p)
+// CreateSecurityGroups creates SecurityGroups at the cluster level and
+// returns their names.
+func CreateSecurityGroups(ctx *pulumi.Context, opts ...pulumi.ResourceOption) ([]string, error) {
+	var names []string
+	for _, g := range SecurityGroups {
+		rules := proxmox.FirewallSecurityGroupRuleArray{}
+		for _, port := range g.Ports {
+			rules = append(rules, &proxmox.FirewallSecurityGroupRuleArgs{
+				Type:    pulumi.String("in"),
+				Action:  pulumi.String("ACCEPT"),
+				Proto:   pulumi.String("tcp"),
+				Dport:   pulumi.String(strconv.Itoa(port)),
+				Comment: pulumi.String(g.Comment),
+			})
+		}
+		_, err := proxmox.NewFirewallSecurityGroup(ctx, "sg-"+g.Name, &proxmox.FirewallSecurityGroupArgs{
+			Name:    pulumi.String(g.Name),
+			Comment: pulumi.String(g.Comment),
+			Rules:   rules,
+		}, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("creating security group %s: %w", g.Name, err)
+		}
+		names = append(names, g.Name)
+	}
+	return names, nil
+}
+
+// AttachSecurityGroups adds the named security groups to a VM's firewall
+// rules. The rules only take effect once the firewall is enabled on the VM
+// and its network device.
+func AttachSecurityGroups(ctx *pulumi.Context, name, node string, vmID int, groups []string, opts ...pulumi.ResourceOption) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	rules := proxmox.FirewallRulesRuleArray{}
+	for _, g := range groups {
+		rules = append(rules, &proxmox.FirewallRulesRuleArgs{
+			SecurityGroup: pulumi.String(g),
+			Comment:       pulumi.String("shared group from the foundation stack"),
+		})
+	}
+	_, err := proxmox.NewFirewallRules(ctx, name, &proxmox.FirewallRulesArgs{
+		NodeName: pulumi.String(node),
+		VmId:     pulumi.Int(vmID),
+		Rules:    rules,
+	}, opts...)
+	if err != nil {
+		return fmt.Errorf("attaching security groups %s: %w", strings.Join(groups, ", "), err)
 	}
-	ctx.Export("firewall_ports", ports)
+	return nil
 }
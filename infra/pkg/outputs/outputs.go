@@ -0,0 +1,106 @@
+// Package outputs defines the stack outputs consumed outside Pulumi.
+//
+// Everything Ansible and the antarctica-infra CLI read from the stack is
+// exported as a single "antarctica" object shaped by Outputs. The object
+// carries a schema_version, and a JSON Schema generated from the struct is
+// committed next to the Ansible inventory, which validates every object it
+// reads against it. Renaming, removing or retyping a field therefore
+// changes the schema, and `antarctica-infra schema -check` refuses the
+// change until SchemaVersion is bumped and the schema regenerated.
+//
+//go:generate go run ../../cmd/antarctica-infra schema -out ../../../ansible/inventory/pulumi_output.schema.json
+package outputs
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/storage"
+)
+
+// Key is the name of the stack output holding Outputs.
+const Key = "antarctica"
+
+// SchemaVersion is the version of the Outputs shape. Bump it on any change
+// to the fields below and regenerate the schema with `go generate`.
+const SchemaVersion = 6
+
+// Outputs is the "antarctica" stack output. Secrets are exported
+// separately (secrets_manifest) so this object stays in plaintext. The doc
+// tags become the field descriptions in the JSON Schema.
+type Outputs struct {
+	SchemaVersion int `pulumi:"schema_version" json:"schema_version" doc:"Version of this object's shape"`
+
+	VMIP       string `pulumi:"vm_ip" json:"vm_ip" doc:"IPv4 address of the VM"`
+	VMHostname string `pulumi:"vm_hostname" json:"vm_hostname" doc:"Hostname"`
+
+	ServiceDomain string `pulumi:"service_domain" json:"service_domain" doc:"Domain the Caddy sites are served under, including a preview's prefix; empty without dns_domain"`
+
+	SSHUser       string   `pulumi:"ssh_user" json:"ssh_user" doc:"Cloud-init user"`
+	SSHPort       int      `pulumi:"ssh_port" json:"ssh_port" doc:"SSH port"`
+	SSHHostKeys   []string `pulumi:"ssh_host_keys" json:"ssh_host_keys" doc:"Pinned SSH host public keys; empty when pinning is disabled"`
+	SSHKnownHosts string   `pulumi:"ssh_known_hosts" json:"ssh_known_hosts" doc:"known_hosts lines for the pinned host keys; empty when pinning is disabled"`
+
+	NetworkBridge  string `pulumi:"network_bridge" json:"network_bridge" doc:"Proxmox bridge the VM is attached to"`
+	NetworkGateway string `pulumi:"network_gateway" json:"network_gateway" doc:"Gateway address; empty with DHCP"`
+	FirewallPorts  []int  `pulumi:"firewall_ports" json:"firewall_ports" doc:"TCP ports to open on the host firewall"`
+
+	DataDiskGB    int              `pulumi:"data_disk_gb" json:"data_disk_gb" doc:"Size of the /data disk in GB"`
+	DataPaths     []string         `pulumi:"data_paths" json:"data_paths" doc:"Directories to create under /data"`
+	DiskSizes     map[string]int   `pulumi:"disk_sizes" json:"disk_sizes" doc:"Disk sizes in GB keyed by Proxmox interface"`
+	PendingFSGrow []storage.FSGrow `pulumi:"pending_fs_grow" json:"pending_fs_grow" doc:"Filesystems to grow after a disk was enlarged"`
+
+	ACMEDNSCredentials string `pulumi:"acme_dns_credentials" json:"acme_dns_credentials" doc:"op:// reference of the GCP key Caddy solves DNS-01 challenges with; empty without acme_dns_zone"`
+	ACMEDNSProject     string `pulumi:"acme_dns_project" json:"acme_dns_project" doc:"GCP project of the DNS-01 zone; empty without acme_dns_zone"`
+
+	PKICACertificate string `pulumi:"pki_ca_certificate" json:"pki_ca_certificate" doc:"Root certificate (PEM) of the private CA; empty without pki"`
+	PKIItem          string `pulumi:"pki_item" json:"pki_item" doc:"op:// reference of the 1Password item holding the issued certificates and keys; empty without pki"`
+
+	DR DR `pulumi:"dr" json:"dr" doc:"Cold standby and its failover steps; empty without dr_node"`
+
+	Mail Mail `pulumi:"mail" json:"mail" doc:"Forgejo mailer settings matching the SPF, DKIM and DMARC records; empty without mail_dns_zone"`
+}
+
+// DR describes the cold standby (see package dr).
+type DR struct {
+	StandbyHostname string   `pulumi:"standby_hostname" json:"standby_hostname" doc:"Hostname of the standby VM"`
+	StandbyNode     string   `pulumi:"standby_node" json:"standby_node" doc:"Proxmox node of the standby"`
+	StandbyVMID     int      `pulumi:"standby_vm_id" json:"standby_vm_id" doc:"VM ID of the standby"`
+	StandbyIP       string   `pulumi:"standby_ip" json:"standby_ip" doc:"IPv4 address of the standby"`
+	ReplicationJob  string   `pulumi:"replication_job" json:"replication_job" doc:"Proxmox replication job copying the data disk"`
+	Active          bool     `pulumi:"active" json:"active" doc:"The standby has taken over; vm_ip is its address"`
+	FailoverSteps   []string `pulumi:"failover_steps" json:"failover_steps" doc:"Failover procedure, in order"`
+}
+
+// Mail is what the Forgejo mailer and its relay need (see package mail).
+type Mail struct {
+	From           string `pulumi:"from" json:"from" doc:"Sender address"`
+	Relay          string `pulumi:"relay" json:"relay" doc:"SMTP relay Forgejo hands mail to, as host:port"`
+	DKIMDomain     string `pulumi:"dkim_domain" json:"dkim_domain" doc:"Domain the relay signs for"`
+	DKIMSelector   string `pulumi:"dkim_selector" json:"dkim_selector" doc:"DKIM selector the public key is published under"`
+	DKIMPrivateKey string `pulumi:"dkim_private_key" json:"dkim_private_key" doc:"op:// reference of the DKIM private key (PEM) the relay signs with"`
+}
+
+// Decode converts the raw "antarctica" output value, as returned by the
+// automation API or `pulumi stack output --json`, into Outputs. Objects
+// written with a different schema version are rejected rather than
+// misread.
+func Decode(value interface{}) (*Outputs, error) {
+	if value == nil {
+		return nil, fmt.Errorf("stack has no %q output; run `mise run deploy:infra` first", Key)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %q output: %w", Key, err)
+	}
+
+	var out Outputs
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decoding %q output: %w", Key, err)
+	}
+	if out.SchemaVersion != SchemaVersion {
+		return nil, fmt.Errorf("%q output has schema_version %d, this program understands %d; "+
+			"update the stack or this checkout", Key, out.SchemaVersion, SchemaVersion)
+	}
+	return &out, nil
+}
@@ -0,0 +1,101 @@
+package outputs
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SchemaID identifies the generated schema document.
+const SchemaID = "urn:nerdsrun:antarctica:pulumi-output"
+
+// Schema returns the JSON Schema (draft 2020-12) of the "antarctica"
+// output, derived from the Outputs struct. schema_version is pinned to
+// SchemaVersion, so a consumer validating against an older schema rejects
+// objects of a newer shape.
+func Schema() map[string]interface{} {
+	schema := objectSchema(reflect.TypeOf(Outputs{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = SchemaID
+	schema["title"] = "Antarctica stack outputs"
+	schema["description"] = fmt.Sprintf("The %q Pulumi stack output consumed by Ansible.", Key)
+
+	props := schema["properties"].(map[string]interface{})
+	version := props["schema_version"].(map[string]interface{})
+	version["const"] = SchemaVersion
+	return schema
+}
+
+// SchemaJSON renders Schema as indented JSON with a trailing newline, the
+// form committed to the repository.
+func SchemaJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding schema: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// SchemaVersionOf returns the pinned schema_version of a schema document,
+// or 0 if it has none.
+func SchemaVersionOf(schemaJSON []byte) int {
+	var doc struct {
+		Properties struct {
+			SchemaVersion struct {
+				Const int `json:"const"`
+			} `json:"schema_version"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(schemaJSON, &doc); err != nil {
+		return 0
+	}
+	return doc.Properties.SchemaVersion.Const
+}
+
+// typeSchema maps a Go type to its JSON Schema.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return objectSchema(t)
+	}
+	panic(fmt.Sprintf("outputs: no JSON Schema mapping for %s", t))
+}
+
+// objectSchema maps a struct to a closed object schema. Every field is
+// required; fields without a json tag are not part of the output.
+func objectSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		prop := typeSchema(f.Type)
+		if doc := f.Tag.Get("doc"); doc != "" {
+			prop["description"] = doc
+		}
+		props[name] = prop
+		required = append(required, name)
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
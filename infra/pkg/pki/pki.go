@@ -0,0 +1,249 @@
+// Package pki runs a private CA for Antarctica with the tls provider.
+//
+// Offline lab copies cannot reach an ACME CA, and Caddy's own internal CA
+// changes with every rebuilt VM. Instead, the stack creates a root CA and
+// an intermediate, and signs a server certificate for every service
+// record (one per Caddy site, see dns.LoadSites) plus a client certificate
+// for the Woodpecker agent's gRPC link. Certificates and keys are stored
+// in one 1Password item per VM for Ansible to install; the root
+// certificate is exported so clients can trust it once.
+//
+// The tls provider keeps the keys as Pulumi secrets in the (encrypted)
+// stack state. `pulumi up` re-issues leaf certificates once they are
+// within EarlyRenewalHours of expiring, and the intermediate within
+// IntermediateEarlyRenewalHours, which re-signs the leaves as well. The
+// root is never renewed.
+package pki
+
+import (
+	"fmt"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/dns"
+	"github.com/nerdsrun/antarctica/infra/pkg/secrets"
+	"github.com/pulumi/pulumi-tls/sdk/v4/go/tls"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Validity periods in hours.
+const (
+	RootValidityHours         = 10 * 365 * 24
+	IntermediateValidityHours = 5 * 365 * 24
+	LeafValidityHours         = 365 * 24
+	// Leaf certificates are replaced during the last 30 days.
+	EarlyRenewalHours = 30 * 24
+	// The intermediate is replaced during its last 180 days, well before
+	// any leaf it signed could outlive it.
+	IntermediateEarlyRenewalHours = 180 * 24
+)
+
+// Organization is the subject organization of every certificate.
+const Organization = "Antarctica"
+
+// WoodpeckerAgent names the client certificate of the Woodpecker agent,
+// presented on the gRPC port (woodpecker_grpc_port, 3041).
+const WoodpeckerAgent = "woodpecker-agent"
+
+// reserved are the item fields' names besides the sites'; a site cannot
+// share one.
+var reserved = map[string]bool{"root": true, "intermediate": true, WoodpeckerAgent: true}
+
+// Config describes the CA of one VM.
+type Config struct {
+	// Domain the service names live under, including any preview prefix
+	// (see dns.ServiceDomain).
+	Domain string
+	// Service records to issue server certificates for.
+	Records []dns.Record
+	// VM hostname; names the CA and the 1Password item.
+	Hostname string
+	// 1Password vault the certificates and keys are stored in.
+	Vault string
+}
+
+// Bundle is what the CA exports.
+type Bundle struct {
+	// Root CA certificate (PEM) for clients to trust.
+	CACertificate pulumi.StringOutput
+	// op:// reference of the 1Password item; certificates and keys are the
+	// fields "<name>_cert" (leaf and intermediate) and "<name>_key".
+	Item pulumi.StringOutput
+}
+
+// ItemTitle is the 1Password item holding hostname's certificates.
+func ItemTitle(hostname string) string {
+	return "antarctica_pki_" + hostname
+}
+
+// Reference is the op:// reference of an item.
+func Reference(vault, title string) string {
+	return fmt.Sprintf("op://%s/%s", vault, title)
+}
+
+// ca is a certificate authority able to sign leaf certificates.
+type ca struct {
+	key  *tls.PrivateKey
+	cert pulumi.StringOutput
+}
+
+// Provision creates the CA, issues the certificates and stores them in
+// 1Password.
+func Provision(ctx *pulumi.Context, cfg Config) (*Bundle, error) {
+	if !secrets.CLIAvailable() {
+		return nil, fmt.Errorf("storing the private CA needs the 1Password CLI (op) in PATH; " +
+			"set antarctica:pki to false to provision without it")
+	}
+
+	for _, rec := range cfg.Records {
+		if reserved[rec.Subdomain] {
+			return nil, fmt.Errorf("site %q would overwrite the %s_cert field of the private CA; rename the site",
+				rec.Subdomain, rec.Subdomain)
+		}
+	}
+
+	root, err := newRoot(ctx, cfg.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	intermediate, err := root.newIntermediate(ctx, cfg.Hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := pulumi.StringMap{
+		"root_cert":         root.cert,
+		"root_key":          root.key.PrivateKeyPem,
+		"intermediate_cert": intermediate.cert,
+		"intermediate_key":  intermediate.key.PrivateKeyPem,
+	}
+	for _, rec := range cfg.Records {
+		name := rec.Subdomain + "." + cfg.Domain
+		cert, key, err := intermediate.issue(ctx, rec.Subdomain, name, []string{name}, "server_auth")
+		if err != nil {
+			return nil, err
+		}
+		fields[rec.Subdomain+"_cert"], fields[rec.Subdomain+"_key"] = cert, key
+	}
+	cert, key, err := intermediate.issue(ctx, WoodpeckerAgent, WoodpeckerAgent+"."+cfg.Domain, nil, "client_auth")
+	if err != nil {
+		return nil, err
+	}
+	fields[WoodpeckerAgent+"_cert"], fields[WoodpeckerAgent+"_key"] = cert, key
+
+	title := ItemTitle(cfg.Hostname)
+	stored := fields.ToStringMapOutput().ApplyT(func(values map[string]string) (string, error) {
+		if !ctx.DryRun() {
+			if err := secrets.SyncItem(cfg.Vault, title, values); err != nil {
+				return "", err
+			}
+		}
+		return Reference(cfg.Vault, title), nil
+	}).(pulumi.StringOutput)
+
+	return &Bundle{
+		CACertificate: root.cert,
+		// The reference depends on the keys but reveals nothing of them.
+		Item: pulumi.Unsecret(stored).(pulumi.StringOutput),
+	}, nil
+}
+
+// newRoot creates the self-signed root CA.
+func newRoot(ctx *pulumi.Context, hostname string) (*ca, error) {
+	key, err := tls.NewPrivateKey(ctx, "pki-root", &tls.PrivateKeyArgs{
+		Algorithm:  pulumi.String("ECDSA"),
+		EcdsaCurve: pulumi.String("P384"),
+	}, pulumi.Protect(true))
+	if err != nil {
+		return nil, fmt.Errorf("creating root CA key: %w", err)
+	}
+	cert, err := tls.NewSelfSignedCert(ctx, "pki-root", &tls.SelfSignedCertArgs{
+		PrivateKeyPem: key.PrivateKeyPem,
+		Subject: &tls.SelfSignedCertSubjectArgs{
+			CommonName:   pulumi.Sprintf("%s Root CA (%s)", Organization, hostname),
+			Organization: pulumi.String(Organization),
+		},
+		IsCaCertificate:     pulumi.Bool(true),
+		SetSubjectKeyId:     pulumi.Bool(true),
+		ValidityPeriodHours: pulumi.Int(RootValidityHours),
+		AllowedUses:         pulumi.ToStringArray([]string{"cert_signing", "crl_signing"}),
+	}, pulumi.Protect(true))
+	if err != nil {
+		return nil, fmt.Errorf("creating root CA certificate: %w", err)
+	}
+	return &ca{key: key, cert: cert.CertPem}, nil
+}
+
+// newIntermediate creates the intermediate CA that signs leaf
+// certificates, so the root key is only needed every few years.
+func (root *ca) newIntermediate(ctx *pulumi.Context, hostname string) (*ca, error) {
+	key, err := tls.NewPrivateKey(ctx, "pki-intermediate", &tls.PrivateKeyArgs{
+		Algorithm:  pulumi.String("ECDSA"),
+		EcdsaCurve: pulumi.String("P384"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating intermediate CA key: %w", err)
+	}
+	csr, err := tls.NewCertRequest(ctx, "pki-intermediate", &tls.CertRequestArgs{
+		PrivateKeyPem: key.PrivateKeyPem,
+		Subject: &tls.CertRequestSubjectArgs{
+			CommonName:   pulumi.Sprintf("%s Intermediate CA (%s)", Organization, hostname),
+			Organization: pulumi.String(Organization),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating intermediate CA request: %w", err)
+	}
+	cert, err := tls.NewLocallySignedCert(ctx, "pki-intermediate", &tls.LocallySignedCertArgs{
+		CertRequestPem:      csr.CertRequestPem,
+		CaPrivateKeyPem:     root.key.PrivateKeyPem,
+		CaCertPem:           root.cert,
+		IsCaCertificate:     pulumi.Bool(true),
+		SetSubjectKeyId:     pulumi.Bool(true),
+		ValidityPeriodHours: pulumi.Int(IntermediateValidityHours),
+		EarlyRenewalHours:   pulumi.Int(IntermediateEarlyRenewalHours),
+		AllowedUses:         pulumi.ToStringArray([]string{"cert_signing", "crl_signing"}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("signing intermediate CA certificate: %w", err)
+	}
+	return &ca{key: key, cert: cert.CertPem}, nil
+}
+
+// issue signs a leaf certificate for use ("server_auth" or "client_auth")
+// and returns the certificate chain (leaf, then intermediate) and the key.
+func (c *ca) issue(ctx *pulumi.Context, name, commonName string, dnsNames []string, use string) (pulumi.StringOutput, pulumi.StringOutput, error) {
+	// Sites are user-defined, so leaf resources get their own prefix to
+	// stay clear of pki-root and pki-intermediate. The alias keeps the
+	// resources created under the old names.
+	resource := "pki-leaf-" + name
+	alias := pulumi.Aliases([]pulumi.Alias{{Name: pulumi.String("pki-" + name)}})
+	key, err := tls.NewPrivateKey(ctx, resource, &tls.PrivateKeyArgs{
+		Algorithm:  pulumi.String("ECDSA"),
+		EcdsaCurve: pulumi.String("P256"),
+	}, alias)
+	if err != nil {
+		return pulumi.StringOutput{}, pulumi.StringOutput{}, fmt.Errorf("creating %s key: %w", name, err)
+	}
+	csr, err := tls.NewCertRequest(ctx, resource, &tls.CertRequestArgs{
+		PrivateKeyPem: key.PrivateKeyPem,
+		DnsNames:      pulumi.ToStringArray(dnsNames),
+		Subject: &tls.CertRequestSubjectArgs{
+			CommonName:   pulumi.String(commonName),
+			Organization: pulumi.String(Organization),
+		},
+	}, alias)
+	if err != nil {
+		return pulumi.StringOutput{}, pulumi.StringOutput{}, fmt.Errorf("creating %s request: %w", name, err)
+	}
+	cert, err := tls.NewLocallySignedCert(ctx, resource, &tls.LocallySignedCertArgs{
+		CertRequestPem:      csr.CertRequestPem,
+		CaPrivateKeyPem:     c.key.PrivateKeyPem,
+		CaCertPem:           c.cert,
+		ValidityPeriodHours: pulumi.Int(LeafValidityHours),
+		EarlyRenewalHours:   pulumi.Int(EarlyRenewalHours),
+		AllowedUses:         pulumi.ToStringArray([]string{"digital_signature", "key_encipherment", use}),
+	}, alias)
+	if err != nil {
+		return pulumi.StringOutput{}, pulumi.StringOutput{}, fmt.Errorf("signing %s certificate: %w", name, err)
+	}
+	return pulumi.Sprintf("%s%s", cert.CertPem, c.cert), key.PrivateKeyPem, nil
+}
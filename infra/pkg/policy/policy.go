@@ -0,0 +1,93 @@
+// Package policy describes the team's guardrails for the Antarctica stack.
+//
+// The rules are a CrossGuard policy pack written with the Python policy
+// SDK in infra/policy, so they run on every `pulumi preview` and
+// `pulumi up` given `--policy-pack policy`; the antarctica-infra commands
+// always pass it. This package lists the policies of that pack for -list
+// and -disable-policy, and must be kept in step with it.
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnforcementLevel says what a violation does to a deploy.
+type EnforcementLevel string
+
+const (
+	// Mandatory violations fail the check.
+	Mandatory EnforcementLevel = "mandatory"
+	// Advisory violations are printed as warnings.
+	Advisory EnforcementLevel = "advisory"
+	// Disabled policies are not evaluated.
+	Disabled EnforcementLevel = "disabled"
+)
+
+// Policy is a single rule of the pack.
+type Policy struct {
+	// Name used in output and to disable the policy.
+	Name        string
+	Description string
+	Level       EnforcementLevel
+}
+
+// HostFirewallPorts is the config property of no-public-database-ports
+// that carries the ports Ansible opens on the host firewall. They are not
+// a resource, so the engine never sees them otherwise.
+const HostFirewallPorts = "hostFirewallPorts"
+
+// Pack lists every policy in the order they are reported.
+var Pack = []Policy{
+	{Name: "dns-min-ttl", Description: "DNS record TTLs must be at least 60 seconds", Level: Mandatory},
+	{Name: "vm-min-memory", Description: "VMs must have at least 4096 MB of dedicated memory", Level: Mandatory},
+	{Name: "vm-ssh-user-not-root", Description: "The cloud-init SSH user must not be root", Level: Mandatory},
+	{Name: "vm-ovmf-efi-disk", Description: "VMs using OVMF firmware must have an EFI disk", Level: Mandatory},
+	{Name: "no-public-database-ports", Description: "PostgreSQL ports 5432/5433 must not be open to any source", Level: Mandatory},
+	{Name: "vm-protected", Description: "VMs must have the Protect resource option", Level: Mandatory},
+}
+
+// Names returns the names of every policy in Pack.
+func Names() []string {
+	names := make([]string, len(Pack))
+	for i, p := range Pack {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// ParseDisabled parses a comma-separated list of policy names, rejecting
+// names that are not in Pack.
+func ParseDisabled(list string) (map[string]bool, error) {
+	known := map[string]bool{}
+	for _, name := range Names() {
+		known[name] = true
+	}
+	disabled := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown policy %q (known: %s)", name, strings.Join(Names(), ", "))
+		}
+		disabled[name] = true
+	}
+	return disabled, nil
+}
+
+// Config returns the pack config for a run: disabled policies are turned
+// off and no-public-database-ports gets the host firewall ports.
+func Config(disabled map[string]bool, hostFirewallPorts []int) map[string]map[string]interface{} {
+	config := map[string]map[string]interface{}{
+		"no-public-database-ports": {HostFirewallPorts: hostFirewallPorts},
+	}
+	for name := range disabled {
+		if config[name] == nil {
+			config[name] = map[string]interface{}{}
+		}
+		config[name]["enforcementLevel"] = Disabled
+	}
+	return config
+}
@@ -0,0 +1,27 @@
+package policy
+
+import "testing"
+
+func TestParseDisabled(t *testing.T) {
+	got, err := ParseDisabled(" vm-protected, dns-min-ttl ,")
+	if err != nil || !got["vm-protected"] || !got["dns-min-ttl"] || len(got) != 2 {
+		t.Errorf("ParseDisabled() = %v, %v", got, err)
+	}
+	if _, err := ParseDisabled("vm-protectd"); err == nil {
+		t.Error("ParseDisabled() accepted an unknown policy")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	got := Config(map[string]bool{"vm-protected": true, "no-public-database-ports": true}, []int{22, 443})
+	if got["vm-protected"]["enforcementLevel"] != Disabled {
+		t.Errorf("vm-protected config = %v, want disabled", got["vm-protected"])
+	}
+	db := got["no-public-database-ports"]
+	if db["enforcementLevel"] != Disabled {
+		t.Errorf("no-public-database-ports config = %v, want disabled", db)
+	}
+	if ports, _ := db[HostFirewallPorts].([]int); len(ports) != 2 {
+		t.Errorf("no-public-database-ports config = %v, want the host ports", db)
+	}
+}
@@ -0,0 +1,161 @@
+// Package preview derives short-lived, per-branch Antarctica environments.
+//
+// A preview environment is a separate Pulumi stack of the same program,
+// seeded from an allow-list of the shared stack's config (see Inherits)
+// with a handful of overrides: a derived VM ID and hostname, DHCP
+// networking, smaller hardware and a DNS prefix so records land at e.g.
+// forgejo.pr-42.dev.nerds.run. Nothing in
+// vm.Provision or dns.CreateRecords is preview-specific; this package only
+// computes the config.
+package preview
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StackPrefix marks stacks created for preview environments.
+const StackPrefix = "preview-"
+
+// DefaultVMIDBase is added to the PR number to derive the VM ID, keeping
+// previews clear of the shared VM (200) and templates (9000+).
+const DefaultVMIDBase = 5000
+
+// TemplateVMIDs is where the VM templates start; no preview VM ID may
+// reach it.
+const TemplateVMIDs = 9000
+
+// Hardware of a preview VM. Enough to converge every Ansible role, small
+// enough that several previews fit next to the shared server.
+const (
+	cpuCores   = 4
+	memoryMB   = 8192
+	dataDiskGB = 20
+)
+
+// Env identifies one preview environment.
+type Env struct {
+	// Pull request (or branch) number the environment belongs to.
+	Number int
+	// Added to Number to derive the Proxmox VM ID.
+	VMIDBase int
+}
+
+// Name returns the short environment name used as the DNS prefix ("pr-42").
+func (e Env) Name() string {
+	return fmt.Sprintf("pr-%d", e.Number)
+}
+
+// StackName returns the Pulumi stack name ("preview-pr-42").
+func (e Env) StackName() string {
+	return StackPrefix + e.Name()
+}
+
+// Hostname derives the VM hostname from the shared stack's base name by
+// replacing any numeric suffix ("antarctica-01" -> "antarctica-pr-42").
+func (e Env) Hostname(base string) string {
+	if i := strings.LastIndex(base, "-"); i > 0 {
+		if _, err := strconv.Atoi(base[i+1:]); err == nil {
+			base = base[:i]
+		}
+	}
+	return fmt.Sprintf("%s-%s", base, e.Name())
+}
+
+// VMID derives the Proxmox VM ID.
+func (e Env) VMID() int {
+	return e.VMIDBase + e.Number
+}
+
+// Overrides returns the antarctica:* config values that turn a copy of the
+// shared stack config into this preview environment.
+func (e Env) Overrides(baseHostname string, expiresAt time.Time) map[string]string {
+	return map[string]string{
+		"hostname":           e.Hostname(baseHostname),
+		"vm_id":              strconv.Itoa(e.VMID()),
+		"cpu_cores":          strconv.Itoa(cpuCores),
+		"memory_mb":          strconv.Itoa(memoryMB),
+		"data_disk_gb":       strconv.Itoa(dataDiskGB),
+		"dns_prefix":         e.Name(),
+		"allow_replace":      "true",
+		"preview_expires_at": expiresAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// inherited lists the antarctica:* config keys a preview copies from the
+// shared stack: placement, the template and the settings that must match
+// it, access, and the zones and vaults its own records and secrets go to.
+// Everything else is left at its default, so a preview never inherits the
+// shared server's static addresses, hardware tuning or passthrough, DR
+// standby, public ingress or mail records. Keys added to stackconfig stay
+// out of previews until they are listed here.
+var inherited = map[string]bool{
+	"proxmox_node":           true,
+	"proxmox_cluster":        true,
+	"proxmox_clusters":       true,
+	"foundation_stack":       true,
+	"attach_security_groups": true,
+	"template_vm_id":         true,
+	"template_node":          true,
+	"cloud_init_template":    true,
+	"bios":                   true,
+	"machine":                true,
+	"cpu_type":               true,
+	"cpu_flags":              true,
+	"virtio_rng":             true,
+	"boot_disk_gb":           true,
+	"storage_pool":           true,
+	"network_bridge":         true,
+	"nameserver":             true,
+	"ssh_user":               true,
+	"ssh_port":               true,
+	"ssh_public_keys":        true,
+	"snippet_datastore":      true,
+	"pin_host_keys":          true,
+	"host_key_vault":         true,
+	"gcp_dns_zone":           true,
+	"dns_domain":             true,
+	"services_file":          true,
+	"acme_dns_zone":          true,
+	"acme_vault":             true,
+	"pki":                    true,
+	"pki_vault":              true,
+	"capacity_cpu_ratio":     true,
+	"capacity_memory_ratio":  true,
+}
+
+// Inherits reports whether a preview copies the antarctica:* config key
+// (without namespace) from the shared stack.
+func Inherits(key string) bool {
+	return inherited[key]
+}
+
+// ParseStackName extracts the environment number from a preview stack
+// name. Fully qualified names ("org/project/preview-pr-42") are accepted.
+func ParseStackName(name string) (int, bool) {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	rest, ok := strings.CutPrefix(name, StackPrefix+"pr-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(rest)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// Expired reports whether a preview_expires_at value lies before now.
+// Unparseable or missing values never expire, so a typo cannot trigger a
+// teardown.
+func Expired(expiresAt string, now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return false
+	}
+	return now.After(t)
+}
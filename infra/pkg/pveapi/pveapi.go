@@ -0,0 +1,325 @@
+// Package pveapi is a minimal client for the Proxmox VE REST API.
+//
+// The Pulumi program talks to Proxmox through the proxmoxve provider. The
+// antarctica-infra CLI needs read access outside of a Pulumi run (import
+// checks, capacity planning), so this package covers just the endpoints it
+// uses, plus what the program needs beyond the provider: the storage
+// replication jobs it has no resource for, and the VM's current disk sizes
+// for the shrink guard. Credentials come from the same environment variables the provider
+// reads, which the ESC environment dev-nerds-run/proxmox sets.
+package pveapi
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the API reports a missing object.
+var ErrNotFound = errors.New("not found")
+
+// Client calls the Proxmox VE API with an API token.
+type Client struct {
+	// Base URL of the cluster (e.g. "https://m0x-01.example:8006/").
+	Endpoint string
+	// API token in "user@realm!tokenid=secret" form.
+	APIToken string
+	// HTTP client used for requests.
+	HTTP *http.Client
+}
+
+// NewFromEnv builds a client from PROXMOX_VE_ENDPOINT, PROXMOX_VE_API_TOKEN
+// and PROXMOX_VE_INSECURE.
+func NewFromEnv() (*Client, error) {
+	endpoint := os.Getenv("PROXMOX_VE_ENDPOINT")
+	token := os.Getenv("PROXMOX_VE_API_TOKEN")
+	if endpoint == "" || token == "" {
+		return nil, errors.New("PROXMOX_VE_ENDPOINT and PROXMOX_VE_API_TOKEN must be set " +
+			"(run under `esc run dev-nerds-run/proxmox`)")
+	}
+	insecure, _ := strconv.ParseBool(os.Getenv("PROXMOX_VE_INSECURE"))
+	return New(endpoint, token, insecure), nil
+}
+
+// New builds a client for the given endpoint and token.
+func New(endpoint, token string, insecure bool) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // opt-in for self-signed clusters
+	}
+	return &Client{
+		Endpoint: endpoint,
+		APIToken: token,
+		HTTP:     &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+// VMConfig is the subset of /nodes/{node}/qemu/{vmid}/config used by the CLI.
+// Disk and network entries keep the raw Proxmox property strings.
+type VMConfig struct {
+	Name      string `json:"name"`
+	Cores     Int    `json:"cores"`
+	Sockets   Int    `json:"sockets"`
+	CPU       string `json:"cpu"`
+	Memory    Int    `json:"memory"`
+	Balloon   Int    `json:"balloon"`
+	BIOS      string `json:"bios"`
+	Machine   string `json:"machine"`
+	SCSI0     string `json:"scsi0"`
+	SCSI1     string `json:"scsi1"`
+	Net0      string `json:"net0"`
+	IPConfig0 string `json:"ipconfig0"`
+	CIUser    string `json:"ciuser"`
+}
+
+// Int decodes integers that Proxmox returns either as JSON numbers or as
+// strings, depending on the endpoint and PVE version.
+type Int int
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (i *Int) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*i = 0
+		return nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("decoding proxmox integer %s: %w", b, err)
+	}
+	*i = Int(n)
+	return nil
+}
+
+// GetVMConfig returns the current configuration of a VM.
+func (c *Client) GetVMConfig(ctx context.Context, node string, vmid int) (*VMConfig, error) {
+	var cfg VMConfig
+	path := fmt.Sprintf("nodes/%s/qemu/%d/config", url.PathEscape(node), vmid)
+	if err := c.get(ctx, path, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// NodeStatus is the subset of /nodes/{node}/status used for capacity
+// planning.
+type NodeStatus struct {
+	CPUInfo struct {
+		// Logical CPUs (threads) of the node.
+		CPUs Int `json:"cpus"`
+	} `json:"cpuinfo"`
+	Memory struct {
+		Total Int `json:"total"`
+		Used  Int `json:"used"`
+	} `json:"memory"`
+}
+
+// GetNodeStatus returns the hardware and usage of a node.
+func (c *Client) GetNodeStatus(ctx context.Context, node string) (*NodeStatus, error) {
+	var status NodeStatus
+	if err := c.get(ctx, fmt.Sprintf("nodes/%s/status", url.PathEscape(node)), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// VMSummary is one entry of /nodes/{node}/qemu.
+type VMSummary struct {
+	VMID   Int    `json:"vmid"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Assigned vCPUs (sockets x cores).
+	CPUs Int `json:"cpus"`
+	// Assigned memory in bytes.
+	MaxMem Int `json:"maxmem"`
+	// Size of the boot disk in bytes.
+	MaxDisk  Int `json:"maxdisk"`
+	Template Int `json:"template"`
+}
+
+// ListVMs returns every VM and template on a node.
+func (c *Client) ListVMs(ctx context.Context, node string) ([]VMSummary, error) {
+	var vms []VMSummary
+	if err := c.get(ctx, fmt.Sprintf("nodes/%s/qemu", url.PathEscape(node)), &vms); err != nil {
+		return nil, err
+	}
+	return vms, nil
+}
+
+// StorageStatus is the subset of /nodes/{node}/storage/{storage}/status
+// used for capacity planning. Sizes are in bytes.
+type StorageStatus struct {
+	Type   string `json:"type"`
+	Total  Int    `json:"total"`
+	Used   Int    `json:"used"`
+	Avail  Int    `json:"avail"`
+	Shared Int    `json:"shared"`
+}
+
+// GetStorageStatus returns the size and usage of a storage pool as seen
+// from a node.
+func (c *Client) GetStorageStatus(ctx context.Context, node, storage string) (*StorageStatus, error) {
+	var status StorageStatus
+	path := fmt.Sprintf("nodes/%s/storage/%s/status", url.PathEscape(node), url.PathEscape(storage))
+	if err := c.get(ctx, path, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ReplicationJob is a storage replication job (/cluster/replication). It
+// copies the disks of guest GuestID to Target on Schedule; disks marked
+// replicate=0 are skipped.
+type ReplicationJob struct {
+	// "<vmid>-<n>", e.g. "200-0".
+	ID     string `json:"id"`
+	Guest  Int    `json:"guest"`
+	Target string `json:"target"`
+	// Calendar event (e.g. "*/15" for every 15 minutes).
+	Schedule string `json:"schedule"`
+	// Bandwidth limit in MB/s; 0 is unlimited.
+	Rate    float64 `json:"rate"`
+	Comment string  `json:"comment"`
+}
+
+// GetReplicationJob returns a replication job by ID.
+func (c *Client) GetReplicationJob(ctx context.Context, id string) (*ReplicationJob, error) {
+	var job ReplicationJob
+	if err := c.get(ctx, "cluster/replication/"+url.PathEscape(id), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// EnsureReplicationJob creates job, or updates its schedule, rate and
+// comment. Proxmox cannot move a job to another target; that has to be
+// done by deleting the job first.
+func (c *Client) EnsureReplicationJob(ctx context.Context, job ReplicationJob) error {
+	form := url.Values{
+		"schedule": {job.Schedule},
+		"comment":  {job.Comment},
+	}
+	if job.Rate > 0 {
+		form.Set("rate", strconv.FormatFloat(job.Rate, 'f', -1, 64))
+	} else {
+		form.Set("delete", "rate")
+	}
+
+	current, err := c.GetReplicationJob(ctx, job.ID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		form.Del("delete")
+		form.Set("id", job.ID)
+		form.Set("type", "local")
+		form.Set("target", job.Target)
+		return c.do(ctx, http.MethodPost, "cluster/replication", form, nil)
+	case err != nil:
+		return err
+	case current.Target != job.Target:
+		return fmt.Errorf("replication job %s targets %s, not %s; remove it with `pvesr delete %s` first",
+			job.ID, current.Target, job.Target, job.ID)
+	case current.Schedule == job.Schedule && current.Rate == job.Rate && current.Comment == job.Comment:
+		return nil
+	}
+	return c.do(ctx, http.MethodPut, "cluster/replication/"+url.PathEscape(job.ID), form, nil)
+}
+
+// get performs a GET request and decodes the "data" envelope into out.
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	return c.do(ctx, http.MethodGet, path, nil, out)
+}
+
+// do performs a request with form as the urlencoded body and decodes the
+// "data" envelope into out, unless out is nil.
+func (c *Client) do(ctx context.Context, method, path string, form url.Values, out interface{}) error {
+	u, err := url.JoinPath(c.Endpoint, "api2/json", path)
+	if err != nil {
+		return fmt.Errorf("building proxmox URL: %w", err)
+	}
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "PVEAPIToken="+c.APIToken)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("proxmox %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading proxmox response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Proxmox answers 500 with "does not exist" for unknown VMIDs.
+		if resp.StatusCode == http.StatusNotFound || strings.Contains(string(data), "does not exist") {
+			return fmt.Errorf("proxmox %s %s: %w", method, path, ErrNotFound)
+		}
+		return fmt.Errorf("proxmox %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+
+	envelope := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("decoding proxmox response: %w", err)
+	}
+	if err := json.Unmarshal(envelope.Data, out); err != nil {
+		return fmt.Errorf("decoding proxmox %s: %w", path, err)
+	}
+	return nil
+}
+
+// PropertyValue extracts key=value from a comma-separated Proxmox property
+// string (e.g. PropertyValue("virtio=AA:BB,bridge=vmbr0", "bridge") ->
+// "vmbr0").
+func PropertyValue(prop, key string) string {
+	for _, part := range strings.Split(prop, ",") {
+		if k, v, ok := strings.Cut(part, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// DiskSizeGB parses the size= value of a disk property (e.g. "size=50G").
+// Returns 0 when the size is missing or not in whole G/T units.
+func DiskSizeGB(disk string) int {
+	size := PropertyValue(disk, "size")
+	if size == "" {
+		return 0
+	}
+	unit := size[len(size)-1]
+	n, err := strconv.Atoi(size[:len(size)-1])
+	if err != nil {
+		return 0
+	}
+	switch unit {
+	case 'G':
+		return n
+	case 'T':
+		return n * 1024
+	}
+	return 0
+}
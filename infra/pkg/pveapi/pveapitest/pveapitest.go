@@ -0,0 +1,546 @@
+// Package pveapitest is an in-memory stand-in for the Proxmox VE API.
+//
+// It serves just enough of /api2/json for the proxmoxve provider to clone,
+// configure, start and inspect a VM on one node: the qemu clone, config,
+// resize and status endpoints, the guest agent's network-get-interfaces,
+// and the task status polling that follows every asynchronous call. Every
+// task finishes immediately and successfully. Requests it does not know
+// are answered with 501 and recorded, so a test can report what a newer
+// provider started calling.
+package pveapitest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi"
+)
+
+// VM is the state of one fake VM.
+type VM struct {
+	ID int
+	// Raw Proxmox config properties (e.g. "memory" -> "8192",
+	// "scsi0" -> "local-lvm:vm-200-disk-0,size=50G").
+	Config map[string]string
+	// "running" or "stopped".
+	Status string
+	// Addresses the guest agent reports for the first NIC while running.
+	// Defaults to the ipconfig0 address, or a made-up DHCP lease.
+	AgentIPv4 []string
+}
+
+// Server is a fake Proxmox VE node behind an httptest TLS server.
+type Server struct {
+	*httptest.Server
+	// Node name served under /nodes/{node}.
+	Node string
+
+	mu        sync.Mutex
+	vms       map[int]*VM
+	tasks     int
+	unhandled []string
+}
+
+// NewServer starts a fake node. Close it when done.
+func NewServer(node string) *Server {
+	s := &Server{Node: node, vms: map[int]*VM{}}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Endpoint returns the base URL to configure as proxmoxve:endpoint.
+func (s *Server) Endpoint() string {
+	return s.URL + "/"
+}
+
+// AddTemplate registers a cloud-init template to clone from.
+func (s *Server) AddTemplate(vmid int, name string, config map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg := map[string]string{"name": name, "template": "1"}
+	for k, v := range config {
+		cfg[k] = v
+	}
+	s.vms[vmid] = &VM{ID: vmid, Config: cfg, Status: "stopped"}
+}
+
+// VM returns a copy of the VM with the given ID.
+func (s *Server) VM(vmid int) (VM, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, ok := s.vms[vmid]
+	if !ok {
+		return VM{}, false
+	}
+	cp := *vm
+	cp.Config = make(map[string]string, len(vm.Config))
+	for k, v := range vm.Config {
+		cp.Config[k] = v
+	}
+	cp.AgentIPv4 = append([]string(nil), vm.AgentIPv4...)
+	return cp, true
+}
+
+// Unhandled returns the requests answered with 501, as "METHOD path".
+func (s *Server) Unhandled() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.unhandled...)
+}
+
+// apiError is answered as a Proxmox error response.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string { return e.message }
+
+func errorf(status int, format string, args ...interface{}) *apiError {
+	return &apiError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// serve dispatches /api2/json requests and wraps results in the "data"
+// envelope.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "PVEAPIToken=") {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"data": nil, "message": "authentication failure"})
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/api2/json/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	params, err := readParams(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"data": nil, "message": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	data, err := s.route(r.Method, strings.Split(strings.Trim(path, "/"), "/"), params)
+	if e, ok := err.(*apiError); ok && e.status == http.StatusNotImplemented {
+		s.unhandled = append(s.unhandled, r.Method+" "+r.URL.Path)
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		if e, ok := err.(*apiError); ok {
+			status = e.status
+		}
+		writeJSON(w, status, map[string]interface{}{"data": nil, "message": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+// route handles one request with s.mu held.
+func (s *Server) route(method string, seg []string, params url.Values) (interface{}, error) {
+	switch {
+	case method == http.MethodGet && match(seg, "version"):
+		return map[string]string{"version": "8.2.4", "release": "8.2", "repoid": "faa83925c9641325"}, nil
+	case method == http.MethodGet && match(seg, "nodes"):
+		return []map[string]interface{}{{"node": s.Node, "status": "online", "type": "node"}}, nil
+	case method == http.MethodGet && match(seg, "cluster", "nextid"):
+		return strconv.Itoa(s.nextID()), nil
+	case method == http.MethodGet && match(seg, "cluster", "resources"):
+		return s.resources(), nil
+	}
+
+	if len(seg) < 3 || seg[0] != "nodes" {
+		return nil, errorf(http.StatusNotImplemented, "not implemented: %s /%s", method, strings.Join(seg, "/"))
+	}
+	if seg[1] != s.Node {
+		return nil, errorf(http.StatusInternalServerError, "hostname lookup '%s' failed - failed to get address info", seg[1])
+	}
+	rest := seg[2:]
+
+	switch {
+	case method == http.MethodGet && match(rest, "tasks", "*", "status"):
+		return map[string]interface{}{"upid": rest[1], "status": "stopped", "exitstatus": "OK", "node": s.Node}, nil
+	case method == http.MethodGet && match(rest, "tasks", "*", "log"):
+		return []map[string]interface{}{{"n": 1, "t": "TASK OK"}}, nil
+	case method == http.MethodGet && match(rest, "storage"):
+		return []map[string]interface{}{}, nil
+	case method == http.MethodGet && match(rest, "qemu"):
+		return s.list(), nil
+	}
+
+	if len(rest) < 2 || rest[0] != "qemu" {
+		return nil, errorf(http.StatusNotImplemented, "not implemented: %s /%s", method, strings.Join(seg, "/"))
+	}
+	vmid, err := strconv.Atoi(rest[1])
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid vmid %q", rest[1])
+	}
+	vm, exists := s.vms[vmid]
+	if !exists {
+		return nil, errorf(http.StatusInternalServerError, "Configuration file 'nodes/%s/qemu-server/%d.conf' does not exist", s.Node, vmid)
+	}
+	op := rest[2:]
+
+	switch {
+	case method == http.MethodDelete && len(op) == 0:
+		delete(s.vms, vmid)
+		return s.task("qmdestroy", vmid), nil
+	case method == http.MethodPost && match(op, "clone"):
+		return s.clone(vm, params)
+	case method == http.MethodGet && match(op, "config"):
+		return configData(vm.Config), nil
+	case method == http.MethodGet && match(op, "pending"):
+		return pendingData(vm.Config), nil
+	case (method == http.MethodPut || method == http.MethodPost) && match(op, "config"):
+		if err := applyConfig(vm, params); err != nil {
+			return nil, err
+		}
+		if method == http.MethodPost {
+			return s.task("qmconfig", vmid), nil
+		}
+		return nil, nil
+	case method == http.MethodPut && match(op, "resize"):
+		if err := resize(vm, params.Get("disk"), params.Get("size")); err != nil {
+			return nil, err
+		}
+		return s.task("qmresize", vmid), nil
+	case method == http.MethodGet && match(op, "status", "current"):
+		return s.statusData(vm), nil
+	case method == http.MethodPost && match(op, "status", "*"):
+		return s.setStatus(vm, op[1])
+	case method == http.MethodGet && match(op, "agent", "network-get-interfaces"):
+		return agentInterfaces(vm)
+	}
+	return nil, errorf(http.StatusNotImplemented, "not implemented: %s /%s", method, strings.Join(seg, "/"))
+}
+
+// clone copies a template into a new, stopped VM.
+func (s *Server) clone(src *VM, params url.Values) (interface{}, error) {
+	newID, err := strconv.Atoi(params.Get("newid"))
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "newid: invalid format")
+	}
+	if _, exists := s.vms[newID]; exists {
+		return nil, errorf(http.StatusInternalServerError, "unable to create VM %d: config file already exists", newID)
+	}
+
+	cfg := make(map[string]string, len(src.Config))
+	for k, v := range src.Config {
+		cfg[k] = strings.ReplaceAll(v, fmt.Sprintf("-%d-disk", src.ID), fmt.Sprintf("-%d-disk", newID))
+	}
+	delete(cfg, "template")
+	if name := params.Get("name"); name != "" {
+		cfg["name"] = name
+	}
+	s.vms[newID] = &VM{ID: newID, Config: cfg, Status: "stopped"}
+	return s.task("qmclone", src.ID), nil
+}
+
+// setStatus handles start, stop, shutdown, reboot and reset.
+func (s *Server) setStatus(vm *VM, action string) (interface{}, error) {
+	switch action {
+	case "start", "reboot", "reset", "resume":
+		vm.Status = "running"
+	case "stop", "shutdown":
+		vm.Status = "stopped"
+	default:
+		return nil, errorf(http.StatusNotImplemented, "not implemented: status/%s", action)
+	}
+	return s.task("qm"+action, vm.ID), nil
+}
+
+func (s *Server) statusData(vm *VM) map[string]interface{} {
+	data := map[string]interface{}{
+		"vmid":      vm.ID,
+		"name":      vm.Config["name"],
+		"status":    vm.Status,
+		"qmpstatus": vm.Status,
+		"agent":     1,
+		"cpus":      atoi(vm.Config["cores"], 1) * atoi(vm.Config["sockets"], 1),
+		"maxmem":    atoi(vm.Config["memory"], 512) * 1024 * 1024,
+	}
+	if vm.Config["template"] == "1" {
+		data["template"] = 1
+	}
+	return data
+}
+
+// task returns a fresh UPID for an asynchronous operation.
+func (s *Server) task(kind string, vmid int) string {
+	s.tasks++
+	return fmt.Sprintf("UPID:%s:%08X:%08X:%08X:%s:%d:root@pam:", s.Node, 1000+s.tasks, s.tasks, time.Now().Unix(), kind, vmid)
+}
+
+func (s *Server) nextID() int {
+	id := 100
+	for {
+		if _, taken := s.vms[id]; !taken {
+			return id
+		}
+		id++
+	}
+}
+
+func (s *Server) list() []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, id := range s.sortedIDs() {
+		out = append(out, s.statusData(s.vms[id]))
+	}
+	return out
+}
+
+func (s *Server) resources() []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, id := range s.sortedIDs() {
+		data := s.statusData(s.vms[id])
+		data["id"] = fmt.Sprintf("qemu/%d", id)
+		data["type"] = "qemu"
+		data["node"] = s.Node
+		out = append(out, data)
+	}
+	return out
+}
+
+func (s *Server) sortedIDs() []int {
+	ids := make([]int, 0, len(s.vms))
+	for id := range s.vms {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// applyConfig sets the posted properties and removes those listed in
+// "delete". Request metadata (digest, background_delay, ...) is ignored.
+func applyConfig(vm *VM, params url.Values) error {
+	for key, values := range params {
+		switch key {
+		case "delete":
+			for _, k := range strings.Split(values[0], ",") {
+				delete(vm.Config, strings.TrimSpace(k))
+			}
+		case "digest", "background_delay", "skiplock", "revert":
+		default:
+			vm.Config[key] = values[0]
+		}
+	}
+	return nil
+}
+
+// resize grows a disk to an absolute ("60G") or relative ("+10G") size.
+func resize(vm *VM, disk, size string) error {
+	prop, ok := vm.Config[disk]
+	if !ok {
+		return errorf(http.StatusBadRequest, "disk '%s' does not exist", disk)
+	}
+	current := pveapi.DiskSizeGB(prop)
+	target := 0
+	if grow, rel := strings.CutPrefix(size, "+"); rel {
+		target = current + atoi(strings.TrimSuffix(grow, "G"), 0)
+	} else {
+		target = atoi(strings.TrimSuffix(size, "G"), 0)
+	}
+	if target < current {
+		return errorf(http.StatusInternalServerError, "shrinking disks is not supported")
+	}
+
+	parts := strings.Split(prop, ",")
+	replaced := false
+	for i, p := range parts {
+		if strings.HasPrefix(p, "size=") {
+			parts[i] = fmt.Sprintf("size=%dG", target)
+			replaced = true
+		}
+	}
+	if !replaced {
+		parts = append(parts, fmt.Sprintf("size=%dG", target))
+	}
+	vm.Config[disk] = strings.Join(parts, ",")
+	return nil
+}
+
+// agentInterfaces answers network-get-interfaces the way the QEMU guest
+// agent does, failing like Proxmox while the VM is stopped.
+func agentInterfaces(vm *VM) (interface{}, error) {
+	if vm.Status != "running" {
+		return nil, errorf(http.StatusInternalServerError, "VM %d is not running", vm.ID)
+	}
+	addrs := vm.AgentIPv4
+	if len(addrs) == 0 {
+		addrs = []string{defaultAddress(vm)}
+	}
+
+	ips := make([]map[string]interface{}, 0, len(addrs))
+	for _, a := range addrs {
+		ips = append(ips, map[string]interface{}{"ip-address": a, "ip-address-type": "ipv4", "prefix": 24})
+	}
+	return map[string]interface{}{
+		"result": []map[string]interface{}{
+			{
+				"name":             "lo",
+				"hardware-address": "00:00:00:00:00:00",
+				"ip-addresses":     []map[string]interface{}{{"ip-address": "127.0.0.1", "ip-address-type": "ipv4", "prefix": 8}},
+			},
+			{
+				"name":             "eth0",
+				"hardware-address": macAddress(vm.Config["net0"]),
+				"ip-addresses":     ips,
+			},
+		},
+	}, nil
+}
+
+// defaultAddress is the ipconfig0 address, or a lease derived from the
+// VM ID when the VM uses DHCP.
+func defaultAddress(vm *VM) string {
+	if ip := pveapi.PropertyValue(vm.Config["ipconfig0"], "ip"); ip != "" && ip != "dhcp" {
+		addr, _, _ := strings.Cut(ip, "/")
+		return addr
+	}
+	return fmt.Sprintf("10.0.%d.%d", vm.ID/250%250, vm.ID%250+2)
+}
+
+// macAddress extracts the MAC from a net0 property ("virtio=AA:..,bridge=").
+func macAddress(net string) string {
+	model, _, _ := strings.Cut(net, ",")
+	if _, mac, ok := strings.Cut(model, "="); ok {
+		return strings.ToLower(mac)
+	}
+	return "bc:24:11:00:00:01"
+}
+
+// configData renders a config with a digest, numeric properties as
+// numbers as Proxmox does.
+func configData(cfg map[string]string) map[string]interface{} {
+	data := make(map[string]interface{}, len(cfg)+1)
+	keys := make([]string, 0, len(cfg))
+	for k, v := range cfg {
+		keys = append(keys, k)
+		if numericKeys[k] {
+			if n, err := strconv.Atoi(v); err == nil {
+				data[k] = n
+				continue
+			}
+		}
+		data[k] = v
+	}
+	sort.Strings(keys)
+	h := sha1.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s: %s\n", k, cfg[k])
+	}
+	data["digest"] = hex.EncodeToString(h.Sum(nil))
+	return data
+}
+
+// pendingData renders a config as the pending-changes list, with nothing
+// pending.
+func pendingData(cfg map[string]string) []map[string]interface{} {
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, map[string]interface{}{"key": k, "value": cfg[k]})
+	}
+	return out
+}
+
+// numericKeys are returned as JSON numbers.
+var numericKeys = map[string]bool{
+	"acpi": true, "balloon": true, "cores": true, "cpulimit": true, "cpuunits": true,
+	"kvm": true, "memory": true, "numa": true, "onboot": true, "protection": true,
+	"sockets": true, "tablet": true, "template": true, "vcpus": true,
+}
+
+// readParams merges query parameters with a form or JSON request body.
+// JSON booleans become "1"/"0" like the form encoding.
+func readParams(r *http.Request) (url.Values, error) {
+	params := r.URL.Query()
+	if r.Body == nil {
+		return params, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return params, nil
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(body, &obj); err != nil {
+			return nil, fmt.Errorf("decoding request body: %w", err)
+		}
+		for k, v := range obj {
+			params.Set(k, formValue(v))
+		}
+		return params, nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("decoding request body: %w", err)
+	}
+	for k, v := range form {
+		params[k] = v
+	}
+	return params, nil
+}
+
+func formValue(v interface{}) string {
+	switch t := v.(type) {
+	case bool:
+		if t {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case string:
+		return t
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// match reports whether seg equals pattern, where "*" matches any segment.
+func match(seg []string, pattern ...string) bool {
+	if len(seg) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && p != seg[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func atoi(s string, defaultVal int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return defaultVal
+	}
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,196 @@
+// Package remote runs commands on the Antarctica VM over a single SSH
+// connection.
+//
+// The mise ops:* tasks open one `ssh` process per command. Go callers (the
+// antarctica-infra CLI) share one connection and open a session per
+// command instead, so concurrent checks cost a single handshake.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultKeyFile is where `mise run deploy:ssh-key` writes the deploy key.
+const DefaultKeyFile = "/tmp/antarctica-deploy.key"
+
+// Config describes how to reach the VM.
+type Config struct {
+	// VM address (IP or hostname).
+	Host string
+	// SSH port.
+	Port int
+	// Login user (the cloud-init user).
+	User string
+	// Private key file. Empty means $ANTARCTICA_SSH_KEY or DefaultKeyFile.
+	KeyFile string
+	// Pinned known_hosts lines (the ssh_known_hosts stack output). When set,
+	// the host key must match one of them. Empty falls back to accept-new.
+	KnownHosts string
+}
+
+// Client is an open SSH connection to the VM.
+type Client struct {
+	conn *ssh.Client
+}
+
+// Dial connects and authenticates with the deploy key. With cfg.KnownHosts
+// set, the host key is checked strictly against it. Otherwise host keys
+// found in ~/.ssh/known_hosts are verified and unknown hosts are accepted,
+// matching StrictHostKeyChecking=accept-new.
+func Dial(ctx context.Context, cfg Config) (*Client, error) {
+	keyFile := cfg.KeyFile
+	if keyFile == "" {
+		keyFile = os.Getenv("ANTARCTICA_SSH_KEY")
+	}
+	if keyFile == "" {
+		keyFile = DefaultKeyFile
+	}
+	pem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading SSH key (run `mise run deploy:ssh-key`): %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SSH key %s: %w", keyFile, err)
+	}
+
+	hostKeyCallback := acceptNewHostKey()
+	if cfg.KnownHosts != "" {
+		hostKeyCallback, err = pinnedHostKey(cfg.KnownHosts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	clientCfg := &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         10 * time.Second,
+	}
+
+	var d net.Dialer
+	raw, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(raw, addr, clientCfg)
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("SSH handshake with %s: %w", addr, err)
+	}
+	return &Client{conn: ssh.NewClient(c, chans, reqs)}, nil
+}
+
+// Run executes cmd in a new session and returns its combined output. A
+// non-zero exit status is returned as an error carrying the output.
+func (c *Client) Run(ctx context.Context, cmd string) (string, error) {
+	return c.RunInput(ctx, cmd, nil)
+}
+
+// RunInput is Run with stdin fed from input, for writing files on the VM
+// without quoting their content into the command line.
+func (c *Client) RunInput(ctx context.Context, cmd string, input []byte) (string, error) {
+	session, err := c.conn.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("opening SSH session: %w", err)
+	}
+	defer session.Close()
+
+	var out bytes.Buffer
+	session.Stdout = &out
+	session.Stderr = &out
+	if input != nil {
+		session.Stdin = bytes.NewReader(input)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Run(cmd) }()
+
+	select {
+	case err := <-done:
+		output := strings.TrimSpace(out.String())
+		if err != nil {
+			if output != "" {
+				return output, fmt.Errorf("%w: %s", err, output)
+			}
+			return output, err
+		}
+		return output, nil
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		return "", ctx.Err()
+	}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// pinnedHostKey accepts only host keys listed in knownHosts, whatever
+// address the VM is reached on.
+func pinnedHostKey(knownHosts string) (ssh.HostKeyCallback, error) {
+	var pinned []ssh.PublicKey
+	rest := []byte(knownHosts)
+	for len(rest) > 0 {
+		_, _, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing pinned known_hosts: %w", err)
+		}
+		pinned = append(pinned, key)
+		rest = next
+	}
+	if len(pinned) == 0 {
+		return nil, errors.New("pinned known_hosts contains no keys")
+	}
+
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		for _, p := range pinned {
+			if bytes.Equal(p.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("host key %s %s of %s does not match the pinned host keys",
+			key.Type(), ssh.FingerprintSHA256(key), hostname)
+	}, nil
+}
+
+// acceptNewHostKey verifies keys of hosts present in ~/.ssh/known_hosts and
+// accepts any host not listed there.
+func acceptNewHostKey() ssh.HostKeyCallback {
+	// Without a readable known_hosts file every host is new.
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	known, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return func(hostname string, addr net.Addr, key ssh.PublicKey) error {
+		err := known(hostname, addr, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return nil // host not listed: accept-new
+		}
+		return err
+	}
+}
@@ -0,0 +1,206 @@
+package secrets
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Reference is an op:// secret reference found in a file.
+type Reference struct {
+	// The full URI as written (e.g. "op://Infrastructure/antiarctica_forgejo/secret-key").
+	URI string
+	// Vault, item and field parsed from the URI. A section, if present, is
+	// not part of the manifest and is dropped.
+	Vault, Item, Field string
+	// Where the reference was found, relative to the scan root.
+	File string
+	Line int
+}
+
+// Finding is one problem reported by CrossCheck.
+type Finding struct {
+	// Human-readable description of the problem.
+	Message string
+	// References that triggered it. Empty for unused manifest fields.
+	References []Reference
+}
+
+// CrossCheckReport is the result of CrossCheck.
+type CrossCheckReport struct {
+	// References to items not in the manifest.
+	UndeclaredItems []Finding
+	// References to fields missing from a declared item.
+	UndeclaredFields []Finding
+	// Manifest fields no reference uses.
+	UnusedFields []Finding
+}
+
+// OK reports whether the references and the manifest agree.
+func (r CrossCheckReport) OK() bool {
+	return len(r.UndeclaredItems)+len(r.UndeclaredFields)+len(r.UnusedFields) == 0
+}
+
+// opURIPattern matches op://vault/item/[section/]field. References with
+// spaces in a segment cannot be matched reliably inside YAML or Jinja and
+// are not used by the Ansible tree.
+var opURIPattern = regexp.MustCompile(`op://[^\s"'` + "`" + `)}]+`)
+
+// ParseReference splits an op:// URI into vault, item and field.
+func ParseReference(uri string) (Reference, error) {
+	rest, ok := strings.CutPrefix(uri, "op://")
+	if !ok {
+		return Reference{}, fmt.Errorf("%q is not an op:// reference", uri)
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) < 3 || len(parts) > 4 {
+		return Reference{}, fmt.Errorf("%q: want op://vault/item/[section/]field", uri)
+	}
+	for _, p := range parts {
+		if p == "" {
+			return Reference{}, fmt.Errorf("%q: empty path segment", uri)
+		}
+	}
+	return Reference{
+		URI:   uri,
+		Vault: parts[0],
+		Item:  parts[1],
+		Field: parts[len(parts)-1],
+	}, nil
+}
+
+// ScanReferences collects every op:// reference in YAML and Jinja files
+// under root. Directories named in skipDirs (e.g. "molecule", "tests"),
+// which hold mock secrets, are not descended into.
+func ScanReferences(root string, skipDirs []string) ([]Reference, error) {
+	skip := map[string]bool{}
+	for _, d := range skipDirs {
+		skip[d] = true
+	}
+
+	var refs []Reference
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && skip[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yml", ".yaml", ".j2":
+		default:
+			return nil
+		}
+
+		found, err := scanFile(root, path)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, found...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning %s for op:// references: %w", root, err)
+	}
+	return refs, nil
+}
+
+// scanFile returns the references in a single file.
+func scanFile(root, path string) ([]Reference, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+
+	var refs []Reference
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		for _, uri := range opURIPattern.FindAllString(scanner.Text(), -1) {
+			ref, err := ParseReference(uri)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", rel, line, err)
+			}
+			ref.File, ref.Line = rel, line
+			refs = append(refs, ref)
+		}
+	}
+	return refs, scanner.Err()
+}
+
+// CrossCheck resolves every reference against the manifest and reports
+// references to undeclared items or fields, and manifest fields nothing
+// references. Findings are sorted for stable output.
+func CrossCheck(refs []Reference, manifest []Item) CrossCheckReport {
+	type itemKey struct{ vault, title string }
+	items := map[itemKey]Item{}
+	for _, item := range manifest {
+		items[itemKey{item.Vault, item.Title}] = item
+	}
+
+	byItem := map[string][]Reference{}
+	byField := map[string][]Reference{}
+	used := map[string]bool{}
+	for _, ref := range refs {
+		item, ok := items[itemKey{ref.Vault, ref.Item}]
+		if !ok {
+			key := ref.Vault + "/" + ref.Item
+			byItem[key] = append(byItem[key], ref)
+			continue
+		}
+		key := ref.Vault + "/" + ref.Item + "/" + ref.Field
+		if _, ok := item.Fields[ref.Field]; !ok {
+			byField[key] = append(byField[key], ref)
+			continue
+		}
+		used[key] = true
+	}
+
+	var report CrossCheckReport
+	for _, key := range sortedKeys(byItem) {
+		report.UndeclaredItems = append(report.UndeclaredItems, Finding{
+			Message:    fmt.Sprintf("item %s is not in the secrets manifest", key),
+			References: byItem[key],
+		})
+	}
+	for _, key := range sortedKeys(byField) {
+		report.UndeclaredFields = append(report.UndeclaredFields, Finding{
+			Message:    fmt.Sprintf("field %s is not declared in the secrets manifest", key),
+			References: byField[key],
+		})
+	}
+	for _, item := range manifest {
+		for _, field := range sortedKeys(item.Fields) {
+			key := item.Vault + "/" + item.Title + "/" + field
+			if !used[key] {
+				report.UnusedFields = append(report.UnusedFields, Finding{
+					Message: fmt.Sprintf("manifest field %s is not referenced anywhere", key),
+				})
+			}
+		}
+	}
+	return report
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
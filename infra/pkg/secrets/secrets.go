@@ -13,9 +13,13 @@
 package secrets
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
@@ -62,11 +66,13 @@ func Manifest() []Item {
 			Vault:    "Infrastructure",
 			Category: "Secure Note",
 			Fields: map[string]string{
-				"secret-key":         "Forgejo internal secret key",
-				"internal-token":     "Forgejo internal API token",
-				"oauth2-jwt-secret":  "OAuth2 JWT signing secret",
-				"lfs-jwt-secret":     "LFS JWT signing secret",
+				"db-password":         "PostgreSQL password for the forgejo database",
+				"secret-key":          "Forgejo internal secret key",
+				"internal-token":      "Forgejo internal API token",
+				"oauth2-jwt-secret":   "OAuth2 JWT signing secret",
+				"lfs-jwt-secret":      "LFS JWT signing secret",
 				"action-runner-token": "Gitea Actions runner registration token",
+				"admin_password":      "Password of the initial Forgejo admin user",
 			},
 		},
 	}
@@ -116,13 +122,179 @@ func opCLIAvailable() bool {
 	return err == nil
 }
 
+// CLIAvailable reports whether the `op` binary is on PATH, for callers that
+// read or write items outside the manifest.
+func CLIAvailable() bool {
+	return opCLIAvailable()
+}
+
+// ErrItemNotFound is returned by ReadFields for items that do not exist.
+var ErrItemNotFound = errors.New("1Password item not found")
+
+// getItem runs `op item get` and returns the item as JSON. op exits with 1
+// for every failure, so only its "isn't an item" message means the item
+// is missing; a signed-out session, an unknown vault or an ambiguous title
+// are returned as errors, never as ErrItemNotFound.
+func getItem(vault, title string, args ...string) ([]byte, error) {
+	args = append([]string{"item", "get", title, "--vault", vault, "--format", "json"}, args...)
+	cmd := exec.Command("op", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if itemMissing(msg) {
+			return nil, fmt.Errorf("%s/%s: %w", vault, title, ErrItemNotFound)
+		}
+		return nil, fmt.Errorf("reading 1Password item %s/%s: %w: %s", vault, title, err, msg)
+	}
+	return out, nil
+}
+
+// itemMissing reports whether op's error output says the item does not
+// exist, e.g. `"x" isn't an item in the "Infrastructure" vault.`
+func itemMissing(stderr string) bool {
+	return strings.Contains(stderr, "isn't an item in")
+}
+
+// ReadFields returns the field values of an item keyed by label.
+func ReadFields(vault, title string) (map[string]string, error) {
+	out, err := getItem(vault, title, "--reveal")
+	if err != nil {
+		return nil, err
+	}
+
+	var item struct {
+		Fields []struct {
+			Label string `json:"label"`
+			Value string `json:"value"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(out, &item); err != nil {
+		return nil, fmt.Errorf("decoding 1Password item %s/%s: %w", vault, title, err)
+	}
+	fields := make(map[string]string, len(item.Fields))
+	for _, f := range item.Fields {
+		fields[f.Label] = f.Value
+	}
+	return fields, nil
+}
+
+// Read returns the value behind an op:// secret reference.
+func Read(reference string) (string, error) {
+	if !opCLIAvailable() {
+		return "", fmt.Errorf("reading %s needs the 1Password CLI (op) in PATH", reference)
+	}
+	out, err := exec.Command("op", "read", "--no-newline", reference).Output()
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", reference, err)
+	}
+	return string(out), nil
+}
+
+// CreateItem creates a Secure Note holding the given values as concealed
+// fields. Values are passed through a private template file rather than
+// the command line, where other users could read them.
+func CreateItem(vault, title string, values map[string]string) error {
+	return withTemplate(title, values, func(template string) error {
+		cmd := exec.Command("op", "item", "create", "--vault", vault, "--template", template)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("creating 1Password item %s/%s: %w: %s", vault, title, err, out)
+		}
+		return nil
+	})
+}
+
+// EditItem replaces the fields of an existing item with the given values
+// in a single `op item edit`, so readers never see the item missing or
+// half written.
+func EditItem(vault, title string, values map[string]string) error {
+	return withTemplate(title, values, func(template string) error {
+		cmd := exec.Command("op", "item", "edit", title, "--vault", vault, "--template", template)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("editing 1Password item %s/%s: %w: %s", vault, title, err, out)
+		}
+		return nil
+	})
+}
+
+// withTemplate writes a Secure Note template holding values as concealed
+// fields to a private temporary file and calls fn with its path.
+func withTemplate(title string, values map[string]string, fn func(template string) error) error {
+	type field struct {
+		Label string `json:"label"`
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	template := struct {
+		Title    string  `json:"title"`
+		Category string  `json:"category"`
+		Fields   []field `json:"fields"`
+	}{Title: title, Category: "SECURE_NOTE"}
+	for label, value := range values {
+		template.Fields = append(template.Fields, field{Label: label, Type: "CONCEALED", Value: value})
+	}
+
+	f, err := os.CreateTemp("", "op-item-*.json")
+	if err != nil {
+		return fmt.Errorf("creating 1Password item template: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if err := json.NewEncoder(f).Encode(template); err != nil {
+		f.Close()
+		return fmt.Errorf("writing 1Password item template: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing 1Password item template: %w", err)
+	}
+	return fn(f.Name())
+}
+
+// SyncItem makes an item hold values: a missing item is created, one whose
+// fields differ is edited in place. An item that already matches is left
+// untouched.
+func SyncItem(vault, title string, values map[string]string) error {
+	fields, err := ReadFields(vault, title)
+	switch {
+	case errors.Is(err, ErrItemNotFound):
+		return CreateItem(vault, title, values)
+	case err != nil:
+		return err
+	case fieldsMatch(fields, values):
+		return nil
+	}
+	return EditItem(vault, title, values)
+}
+
+// fieldsMatch reports whether fields holds every value in want.
+func fieldsMatch(fields, want map[string]string) bool {
+	for label, value := range want {
+		if fields[label] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// DeleteItem deletes an item. Deleting a missing item is not an error.
+func DeleteItem(vault, title string) error {
+	exists, err := itemExists(vault, title)
+	if err != nil || !exists {
+		return err
+	}
+	cmd := exec.Command("op", "item", "delete", title, "--vault", vault)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("deleting 1Password item %s/%s: %w: %s", vault, title, err, out)
+	}
+	return nil
+}
+
 // itemExists checks whether a 1Password item with the given title exists
 // in the specified vault.
 func itemExists(vault, title string) (bool, error) {
-	cmd := exec.Command("op", "item", "get", title, "--vault", vault, "--format", "json")
-	if err := cmd.Run(); err != nil {
-		// Exit code 1 means "not found", which is not an error for us.
-		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+	if _, err := getItem(vault, title); err != nil {
+		// A missing item is not an error for us; anything else is.
+		if errors.Is(err, ErrItemNotFound) {
 			return false, nil
 		}
 		return false, err
@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestItemMissing(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{`[ERROR] 2026/10/19 12:00:00 "antarctica_dkim_antarctica" isn't an item in the "Infrastructure" vault. Specify the item with its UUID, name, or domain.`, true},
+		{`[ERROR] 2026/10/19 12:00:00 You are not currently signed in. Please run ` + "`op signin --help`" + ` for instructions`, false},
+		{`[ERROR] 2026/10/19 12:00:00 "Infrastucture" isn't a vault in this account. Specify the vault with its ID or name.`, false},
+		{`[ERROR] 2026/10/19 12:00:00 More than one item matches "antarctica". Try again and specify the item by its ID`, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := itemMissing(tt.stderr); got != tt.want {
+			t.Errorf("itemMissing(%q) = %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+// fakeOp puts an `op` on PATH that logs its subcommands and answers
+// `op item get` with item (or "isn't an item" when empty), and returns the
+// path of the log.
+func fakeOp(t *testing.T, item string) string {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls")
+	get := `echo '"x" isn'"'"'t an item in the "v" vault.' >&2; exit 1`
+	if item != "" {
+		get = "cat <<'ITEM'\n" + item + "\nITEM"
+	}
+	script := "#!/bin/sh\necho \"$1 $2\" >> " + log + "\n" +
+		"if [ \"$2\" = get ]; then\n" + get + "\nfi\n"
+	if err := os.WriteFile(filepath.Join(dir, "op"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestSyncItem(t *testing.T) {
+	tests := []struct {
+		name string
+		item string
+		want []string
+	}{
+		{"missing", "", []string{"item get", "item create"}},
+		{"differs", `{"fields": [{"label": "key", "value": "old"}]}`, []string{"item get", "item edit"}},
+		{"matches", `{"fields": [{"label": "key", "value": "new"}]}`, []string{"item get"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := fakeOp(t, tt.item)
+			if err := SyncItem("v", "x", map[string]string{"key": "new"}); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Split(strings.TrimSpace(string(data)), "\n"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("op calls = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
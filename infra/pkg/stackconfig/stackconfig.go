@@ -0,0 +1,363 @@
+// Package stackconfig reads the antarctica:* stack config into typed values.
+//
+// The Pulumi program and the antarctica-infra CLI both need the same view of
+// the stack config: the program to provision resources, the CLI to compare
+// live infrastructure against it. Keeping the parsing and defaults here
+// guarantees they never disagree.
+package stackconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/capacity"
+	"github.com/nerdsrun/antarctica/infra/pkg/cluster"
+	"github.com/nerdsrun/antarctica/infra/pkg/dns"
+	"github.com/nerdsrun/antarctica/infra/pkg/dr"
+	"github.com/nerdsrun/antarctica/infra/pkg/mail"
+	"github.com/nerdsrun/antarctica/infra/pkg/vm"
+)
+
+// Namespace is the Pulumi config namespace of every key read here.
+const Namespace = "antarctica"
+
+// Getter returns the raw value of a config key within Namespace, or "" when
+// unset. Structured values (lists, objects) are returned as JSON, matching
+// config.Config.Get.
+type Getter func(key string) string
+
+// Stack holds the parsed stack config.
+type Stack struct {
+	// VM settings passed to vm.Provision.
+	VM vm.Config
+	// SSH port exported for Ansible.
+	SSHPort int
+	// GCP managed zone for service records. Empty disables DNS.
+	GCPDNSZone string
+	// Base domain for service records (e.g. "dev.nerds.run").
+	DNSDomain string
+	// Label inserted between service and domain (e.g. "pr-42" for
+	// forgejo.pr-42.dev.nerds.run). Set on preview environments.
+	DNSPrefix string
+	// Public zone and ingress address for the sites marked public
+	// (split-horizon, see dns.PublicConfig). Empty keeps every record
+	// private.
+	PublicDNS dns.PublicConfig
+	// Ansible vars file listing the Caddy sites the service records are
+	// derived from (see dns.LoadSites), relative to the program directory.
+	ServicesFile string
+	// Managed zones holding the PTR records of the VM's IPv4 and IPv6
+	// addresses (see dns.CreatePTRRecords). Empty skips that family.
+	ReverseDNSZone   string
+	ReverseDNSZoneV6 string
+	// Escape hatch for protected resources (VM, PTR and mail records).
+	AllowReplace bool
+	// Generate the VM's SSH host keys ahead of time and inject them through
+	// cloud-init (see package hostkeys). On by default; false is the
+	// explicit opt-out, which leaves clients on accept-new.
+	PinHostKeys bool
+	// 1Password vault holding the pinned host keys.
+	HostKeyVault string
+	// Foundation stack providing the zone, network and template defaults
+	// (see package foundation). Empty means everything comes from this
+	// stack's config.
+	FoundationStack string
+	// Attach the foundation's Proxmox firewall security groups to the VM.
+	AttachSecurityGroups bool
+	// Public managed zone Caddy answers ACME DNS-01 challenges in (see
+	// package acme). Empty leaves Caddy on its internal CA.
+	ACMEDNSZone string
+	// 1Password vault holding the DNS-01 service account key.
+	ACMEVault string
+	// Run a private CA issuing the service certificates (see package pki).
+	PKI bool
+	// 1Password vault holding the CA's certificates and keys.
+	PKIVault string
+	// SPF, DKIM and DMARC records for Forgejo's mail (see package mail).
+	Mail mail.Config
+	// Overcommit ratios the Proxmox node may reach (see package capacity).
+	Capacity capacity.Limits
+	// Proxmox clusters besides the default one, which comes from the
+	// proxmoxve:* config (see package cluster). VM.Cluster names the one
+	// the VM is placed on.
+	Clusters map[string]cluster.Config
+	// Cold standby on a second node (see package dr).
+	DR dr.Config
+}
+
+// DefaultServicesFile holds the Caddy sites Ansible deploys.
+const DefaultServicesFile = "../ansible/inventory/group_vars/antarctica.yml"
+
+// Records reads the service records from ServicesFile, resolved against
+// the program directory dir.
+func (s *Stack) Records(dir string) ([]dns.Record, error) {
+	path := s.ServicesFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return dns.LoadSites(path)
+}
+
+// Load parses the stack config, applying the same defaults the program has
+// always used. inherited holds the values of a foundation stack (see
+// foundation.Outputs.ConfigDefaults), or nil. Keys set in the stack win
+// over the VM's cluster defaults, which win over inherited values.
+func Load(get Getter, inherited map[string]string) (*Stack, error) {
+	var clusters map[string]cluster.Config
+	if err := (reader{get: get}).object("proxmox_clusters", &clusters); err != nil {
+		return nil, err
+	}
+	clusterName := get("proxmox_cluster")
+	if clusterName == "" {
+		clusterName = cluster.DefaultName
+	}
+	placement, ok := clusters[clusterName]
+	if !ok && clusterName != cluster.DefaultName {
+		return nil, fmt.Errorf("'%s:proxmox_cluster' is %q, which '%s:proxmox_clusters' does not define",
+			Namespace, clusterName, Namespace)
+	}
+	get = WithDefaults(WithDefaults(get, clusterDefaults(placement)), inherited)
+	r := reader{get: get}
+
+	node := get("proxmox_node")
+	if node == "" {
+		return nil, fmt.Errorf("missing required configuration variable '%s:proxmox_node'", Namespace)
+	}
+
+	s := &Stack{
+		SSHPort:      r.int("ssh_port", 22),
+		GCPDNSZone:   get("gcp_dns_zone"),
+		DNSDomain:    get("dns_domain"),
+		DNSPrefix:    get("dns_prefix"),
+		ServicesFile: r.string("services_file", DefaultServicesFile),
+		AllowReplace: r.bool("allow_replace", false),
+		PinHostKeys:  r.bool("pin_host_keys", true),
+		HostKeyVault: r.string("host_key_vault", "Infrastructure"),
+
+		FoundationStack:      get("foundation_stack"),
+		AttachSecurityGroups: r.bool("attach_security_groups", false),
+
+		ACMEDNSZone: get("acme_dns_zone"),
+		ACMEVault:   r.string("acme_vault", "Infrastructure"),
+		PKI:         r.bool("pki", false),
+		PKIVault:    r.string("pki_vault", "Infrastructure"),
+
+		Capacity: capacity.Limits{
+			CPURatio:    r.float("capacity_cpu_ratio", capacity.DefaultCPURatio),
+			MemoryRatio: r.float("capacity_memory_ratio", capacity.DefaultMemoryRatio),
+		},
+
+		Clusters: clusters,
+	}
+	if s.AttachSecurityGroups && s.FoundationStack == "" {
+		return nil, fmt.Errorf("'%s:attach_security_groups' needs '%s:foundation_stack'", Namespace, Namespace)
+	}
+	// The foundation creates its security groups on the default cluster.
+	if s.AttachSecurityGroups && clusterName != cluster.DefaultName {
+		return nil, fmt.Errorf("'%s:attach_security_groups' only works on the %q cluster", Namespace, cluster.DefaultName)
+	}
+
+	s.VM = vm.Config{
+		Cluster:           clusterName,
+		Node:              node,
+		VMID:              r.int("vm_id", 200),
+		TemplateVMID:      r.int("template_vm_id", 9000),
+		TemplateNode:      get("template_node"),
+		Hostname:          r.string("hostname", "antarctica"),
+		CPUCores:          r.int("cpu_cores", 4),
+		CPUSockets:        r.int("cpu_sockets", 1),
+		CPUType:           r.string("cpu_type", "host"),
+		CPUUnits:          r.int("cpu_units", 0),
+		CPULimit:          r.int("cpu_limit", 0),
+		NUMA:              r.bool("numa", false),
+		MemoryMB:          r.int("memory_mb", 8192),
+		Hugepages:         get("hugepages"),
+		BalloonMinMB:      r.int("balloon_min_mb", 0),
+		BIOS:              r.string("bios", "ovmf"),
+		Machine:           r.string("machine", "q35"),
+		VirtIORNG:         r.bool("virtio_rng", false),
+		BootDiskGB:        r.int("boot_disk_gb", 50),
+		DataDiskGB:        r.int("data_disk_gb", 100),
+		CloudInitTemplate: r.string("cloud_init_template", "debian-12-cloudinit"),
+		StoragePool:       r.string("storage_pool", "local-lvm"),
+		NetworkBridge:     r.string("network_bridge", "vmbr0"),
+		IPAddress:         get("ip_address"),
+		Gateway:           get("gateway"),
+		IPv6Address:       get("ipv6_address"),
+		IPv6Gateway:       get("ipv6_gateway"),
+		Nameserver:        get("nameserver"),
+		SSHPublicKeys:     get("ssh_public_keys"),
+		SSHUser:           r.string("ssh_user", "antarctica"),
+		SnippetDatastore:  r.string("snippet_datastore", "local"),
+		AllowReplace:      s.AllowReplace,
+	}
+
+	s.DR = dr.Config{
+		Node:      get("dr_node"),
+		VMID:      r.int("dr_vm_id", s.VM.VMID+1),
+		IPAddress: get("dr_ip_address"),
+		Schedule:  r.string("dr_replication_schedule", dr.DefaultSchedule),
+		RateMBps:  r.float("dr_replication_rate", 0),
+		Active:    r.bool("dr_active", false),
+	}
+	if name := get("dr_cluster"); name != "" && name != s.VM.Cluster {
+		standby, ok := clusters[name]
+		if !ok {
+			return nil, fmt.Errorf("'%s:dr_cluster' is %q, which '%s:proxmox_clusters' does not define",
+				Namespace, name, Namespace)
+		}
+		s.DR.Cluster = name
+		s.DR.TemplateVMID = standby.TemplateVMID
+		s.DR.TemplateNode = standby.TemplateNode
+		s.DR.StoragePool = standby.StoragePool
+	}
+	if s.DR.Enabled() {
+		// Only a standby in the same cluster gets a replica of the data
+		// disk; see package dr.
+		s.VM.ReplicateDataOnly = !s.DR.CrossCluster(s.VM)
+		if err := s.DR.Validate(s.VM); err != nil {
+			return nil, fmt.Errorf("'%s:dr_node': %w", Namespace, err)
+		}
+	} else if s.DR.Active {
+		return nil, fmt.Errorf("'%s:dr_active' needs a standby in '%s:dr_node'", Namespace, Namespace)
+	}
+
+	// PTR records name a static address under the service domain.
+	s.ReverseDNSZone = get("reverse_dns_zone")
+	s.ReverseDNSZoneV6 = get("reverse_dns_zone_v6")
+	if s.ReverseDNSZone != "" && s.VM.IPAddress == "" {
+		return nil, fmt.Errorf("'%s:reverse_dns_zone' needs a static '%s:ip_address'", Namespace, Namespace)
+	}
+	if s.ReverseDNSZoneV6 != "" && s.VM.IPv6Address == "" {
+		return nil, fmt.Errorf("'%s:reverse_dns_zone_v6' needs '%s:ipv6_address'", Namespace, Namespace)
+	}
+	if (s.ReverseDNSZone != "" || s.ReverseDNSZoneV6 != "") && s.DNSDomain == "" {
+		return nil, fmt.Errorf("reverse DNS needs '%s:dns_domain' to name the VM", Namespace)
+	}
+
+	// A gateway inherited from the foundation is meaningless with DHCP.
+	if s.VM.IPAddress == "" {
+		s.VM.Gateway = ""
+	}
+
+	// Structured values (lists of flags and devices) are YAML objects in the
+	// stack config and arrive here as JSON.
+	if err := r.object("cpu_flags", &s.VM.CPUFlags); err != nil {
+		return nil, err
+	}
+	if err := r.object("pci_devices", &s.VM.PCIDevices); err != nil {
+		return nil, err
+	}
+	if err := r.object("usb_devices", &s.VM.USBDevices); err != nil {
+		return nil, err
+	}
+	s.Mail = mail.Config{
+		Zone:         get("mail_dns_zone"),
+		Domain:       r.string("mail_domain", dns.ServiceDomain(s.DNSDomain, s.DNSPrefix)),
+		From:         get("mail_from"),
+		SPF:          get("mail_spf"),
+		Selector:     r.string("mail_dkim_selector", mail.DefaultSelector),
+		DMARCPolicy:  r.string("mail_dmarc_policy", mail.DefaultDMARC),
+		DMARCReports: get("mail_dmarc_reports"),
+		Relay:        get("mail_relay"),
+		Hostname:     s.VM.Hostname,
+		Vault:        r.string("mail_vault", "Infrastructure"),
+		AllowReplace: s.AllowReplace,
+	}
+	if s.Mail.Enabled() {
+		if err := s.Mail.Validate(); err != nil {
+			return nil, fmt.Errorf("'%s:mail_*': %w", Namespace, err)
+		}
+	}
+
+	s.PublicDNS = dns.PublicConfig{
+		Zone:    get("public_dns_zone"),
+		Address: get("public_address"),
+	}
+	if err := s.PublicDNS.Validate(); err != nil {
+		return nil, fmt.Errorf("'%s:public_dns_zone': %w", Namespace, err)
+	}
+
+	return s, nil
+}
+
+// clusterDefaults maps a cluster's VM defaults onto config keys.
+func clusterDefaults(c cluster.Config) map[string]string {
+	defaults := map[string]string{
+		"template_node": c.TemplateNode,
+		"storage_pool":  c.StoragePool,
+	}
+	if c.TemplateVMID != 0 {
+		defaults["template_vm_id"] = strconv.Itoa(c.TemplateVMID)
+	}
+	return defaults
+}
+
+// WithDefaults returns a Getter that falls back to defaults for keys get
+// leaves unset, e.g. the values inherited from a foundation stack.
+func WithDefaults(get Getter, defaults map[string]string) Getter {
+	return func(key string) string {
+		if v := get(key); v != "" {
+			return v
+		}
+		return defaults[key]
+	}
+}
+
+// reader wraps a Getter with typed accessors.
+type reader struct {
+	get Getter
+}
+
+// string reads a string config value with a default fallback.
+func (r reader) string(key, defaultVal string) string {
+	if v := r.get(key); v != "" {
+		return v
+	}
+	return defaultVal
+}
+
+// int reads an integer config value with a default fallback.
+func (r reader) int(key string, defaultVal int) int {
+	raw := r.get(key)
+	if raw == "" {
+		return defaultVal
+	}
+	val, err := strconv.Atoi(raw)
+	if err != nil {
+		return defaultVal
+	}
+	return val
+}
+
+// float reads a decimal config value with a default fallback.
+func (r reader) float(key string, defaultVal float64) float64 {
+	val, err := strconv.ParseFloat(r.get(key), 64)
+	if err != nil {
+		return defaultVal
+	}
+	return val
+}
+
+// bool reads a boolean config value with a default fallback.
+func (r reader) bool(key string, defaultVal bool) bool {
+	val, err := strconv.ParseBool(r.get(key))
+	if err != nil {
+		return defaultVal
+	}
+	return val
+}
+
+// object decodes a structured config value. Unset keys leave out untouched.
+func (r reader) object(key string, out interface{}) error {
+	raw := r.get(key)
+	if raw == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(raw), out); err != nil {
+		return fmt.Errorf("reading %s: %w", key, err)
+	}
+	return nil
+}
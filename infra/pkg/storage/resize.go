@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Disk describes a VM disk and the filesystem Ansible places on it.
+type Disk struct {
+	// Proxmox disk interface (e.g. "scsi1").
+	Interface string
+	// Block device as seen by the guest (e.g. "/dev/sdb").
+	Device string
+	// Partition number holding the filesystem. Zero means the filesystem
+	// spans the whole device.
+	Partition int
+	// Mount point inside the guest.
+	MountPoint string
+	// Requested size in gigabytes.
+	SizeGB int
+}
+
+// FSGrow is a filesystem that must be grown after its disk was enlarged.
+// Exported as part of the pending_fs_grow stack output, which reports the
+// growth of the last update only; the Ansible base role checks every
+// filesystem against its device on each run instead of relying on it.
+type FSGrow struct {
+	MountPoint string `pulumi:"mount_point" json:"mount_point" doc:"Mount point inside the guest"`
+	Device     string `pulumi:"device" json:"device" doc:"Block device as seen by the guest"`
+	Partition  int    `pulumi:"partition" json:"partition" doc:"Partition number; 0 when the filesystem spans the device"`
+	FromGB     int    `pulumi:"from_gb" json:"from_gb" doc:"Previous disk size in GB"`
+	ToGB       int    `pulumi:"to_gb" json:"to_gb" doc:"New disk size in GB"`
+	// Commands to run, in order ("growpart" then "resize2fs", or just
+	// "resize2fs" for whole-device filesystems).
+	Actions []string `pulumi:"actions" json:"actions" doc:"Commands to run in order"`
+}
+
+// Layout returns the disks provisioned by the VM module. The boot disk
+// carries a partitioned cloud image; the data disk is formatted whole.
+// The Ansible base role lists the same filesystems in base_filesystems.
+func Layout(bootDiskGB, dataDiskGB int) []Disk {
+	return []Disk{
+		{Interface: "scsi0", Device: "/dev/sda", Partition: 1, MountPoint: "/", SizeGB: bootDiskGB},
+		{Interface: "scsi1", Device: "/dev/sdb", MountPoint: "/data", SizeGB: dataDiskGB},
+	}
+}
+
+// CurrentSizes reads the disk sizes of VM vmid on node from Proxmox, keyed
+// by interface, for CheckResize. A VM that does not exist yet returns an
+// empty map, and disks whose size is not in whole gigabytes are left out.
+func CurrentSizes(ctx context.Context, client *pveapi.Client, node string, vmid int, disks []Disk) (map[string]int, error) {
+	live, err := client.GetVMConfig(ctx, node, vmid)
+	switch {
+	case errors.Is(err, pveapi.ErrNotFound):
+		return map[string]int{}, nil
+	case err != nil:
+		return nil, fmt.Errorf("reading the disk sizes of VM %d: %w", vmid, err)
+	}
+	props := map[string]string{"scsi0": live.SCSI0, "scsi1": live.SCSI1}
+	sizes := map[string]int{}
+	for _, d := range disks {
+		if gb := pveapi.DiskSizeGB(props[d.Interface]); gb > 0 {
+			sizes[d.Interface] = gb
+		}
+	}
+	return sizes, nil
+}
+
+// CheckResize compares the requested disk sizes with previous, the sizes
+// the VM has now (see CurrentSizes).
+// Proxmox can only grow disks in place, so any shrink is refused before the
+// VM resource is touched. Growth is allowed and returned for the
+// pending_fs_grow output; Pulumi itself never touches the guest, and the
+// Ansible base role (base_filesystems) extends the filesystems.
+//
+// Must be called before vm.Provision.
+func CheckResize(ctx *pulumi.Context, previous map[string]int, disks []Disk) ([]FSGrow, error) {
+	pending := []FSGrow{}
+	for _, d := range disks {
+		old, ok := previous[d.Interface]
+		if !ok || old == d.SizeGB {
+			continue
+		}
+		if d.SizeGB < old {
+			return nil, fmt.Errorf(
+				"refusing to shrink %s (%s) from %d GB to %d GB: Proxmox cannot shrink disks in place",
+				d.Interface, d.MountPoint, old, d.SizeGB)
+		}
+
+		grow := FSGrow{
+			MountPoint: d.MountPoint,
+			Device:     d.Device,
+			Partition:  d.Partition,
+			FromGB:     old,
+			ToGB:       d.SizeGB,
+			Actions:    []string{"resize2fs"},
+		}
+		if d.Partition > 0 {
+			grow.Actions = []string{"growpart", "resize2fs"}
+		}
+		pending = append(pending, grow)
+
+		ctx.Log.Info(fmt.Sprintf("Disk %s (%s) grows from %d GB to %d GB; filesystem resize pending",
+			d.Interface, d.MountPoint, old, d.SizeGB), nil)
+	}
+	return pending, nil
+}
+
+// Sizes returns the disk sizes keyed by Proxmox interface, for the
+// disk_sizes output.
+func Sizes(disks []Disk) map[string]int {
+	sizes := make(map[string]int, len(disks))
+	for _, d := range disks {
+		sizes[d.Interface] = d.SizeGB
+	}
+	return sizes
+}
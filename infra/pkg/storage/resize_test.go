@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi"
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi/pveapitest"
+)
+
+func TestCurrentSizes(t *testing.T) {
+	srv := pveapitest.NewServer("pve")
+	defer srv.Close()
+	srv.AddTemplate(200, "antarctica-01", map[string]string{
+		"scsi0": "sharedx:vm-200-disk-0,size=50G",
+		"scsi1": "sharedx:vm-200-disk-1,iothread=1,size=1T",
+	})
+	client := pveapi.New(srv.Endpoint(), "root@pam!test=secret", true)
+	disks := Layout(50, 180)
+
+	got, err := CurrentSizes(context.Background(), client, "pve", 200, disks)
+	if err != nil {
+		t.Fatalf("CurrentSizes() error = %v", err)
+	}
+	if len(got) != 2 || got["scsi0"] != 50 || got["scsi1"] != 1024 {
+		t.Errorf("CurrentSizes() = %v, want scsi0 50 and scsi1 1024", got)
+	}
+
+	got, err = CurrentSizes(context.Background(), client, "pve", 201, disks)
+	if err != nil || len(got) != 0 {
+		t.Errorf("CurrentSizes() of a missing VM = %v, %v, want an empty map", got, err)
+	}
+}
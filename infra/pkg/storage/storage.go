@@ -16,12 +16,9 @@
 //	/data/docker         -> Docker data root
 package storage
 
-import (
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-)
-
 // DataPaths enumerates the directories Ansible should create under /data.
-// Exported as a stack output so the Ansible inventory can reference them.
+// Exported in the data_paths stack output so the Ansible inventory can
+// reference them.
 var DataPaths = []string{
 	"/data/containers",
 	"/data/forgejo",
@@ -30,10 +27,3 @@ var DataPaths = []string{
 	"/data/caddy",
 	"/data/openvscode",
 }
-
-// ExportDataLayout registers the expected /data subdirectories as a stack
-// output. Ansible reads these to create mount points and bind mounts.
-func ExportDataLayout(ctx *pulumi.Context, dataDiskGB int) {
-	ctx.Export("data_disk_gb", pulumi.Int(dataDiskGB))
-	ctx.Export("data_paths", pulumi.ToStringArray(DataPaths))
-}
@@ -0,0 +1,241 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	proxmox "github.com/muhlba91/pulumi-proxmoxve/sdk/v6/go/proxmoxve/vm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// PCIDevice describes a host PCI device passed through to the guest.
+type PCIDevice struct {
+	// Host PCI address (e.g. "0000:01:00.0"). Use either this or Mapping.
+	ID string `json:"id,omitempty"`
+	// Cluster-wide resource mapping name. Use either this or ID.
+	Mapping string `json:"mapping,omitempty"`
+	// Expose the device on the PCIe bus (requires the q35 machine type).
+	PCIe bool `json:"pcie,omitempty"`
+	// Hide the device's option ROM from the guest.
+	HideROM bool `json:"hide_rom,omitempty"`
+}
+
+// USBDevice describes a host USB device passed through to the guest.
+type USBDevice struct {
+	// Host USB device ("vendor:product") or port ("bus-port"). Use either this or Mapping.
+	Host string `json:"host,omitempty"`
+	// Cluster-wide resource mapping name. Use either this or Host.
+	Mapping string `json:"mapping,omitempty"`
+	// Attach as a USB3 device.
+	USB3 bool `json:"usb3,omitempty"`
+}
+
+// Proxmox limits for passthrough slots and CPU weight.
+const (
+	maxPCIDevices = 16
+	maxUSBDevices = 14
+	maxCPUUnits   = 262144
+)
+
+// hugepageSizesMB maps accepted Hugepages values to the page size in MB.
+// "any" lets QEMU pick, so only the 2 MB granularity is enforced.
+var hugepageSizesMB = map[string]int{
+	"2":    2,
+	"1024": 1024,
+	"any":  2,
+}
+
+// Validate checks the hardware settings for combinations Proxmox would
+// reject or that would silently misbehave at runtime.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.CPUCores < 1 {
+		errs = append(errs, fmt.Errorf("cpu cores must be at least 1, got %d", c.CPUCores))
+	}
+	if c.CPUSockets < 1 {
+		errs = append(errs, fmt.Errorf("cpu sockets must be at least 1, got %d", c.CPUSockets))
+	}
+	if c.CPUType == "" {
+		errs = append(errs, errors.New("cpu type must not be empty"))
+	}
+	for _, flag := range c.CPUFlags {
+		if len(flag) < 2 || (flag[0] != '+' && flag[0] != '-') {
+			errs = append(errs, fmt.Errorf("cpu flag %q must be prefixed with + or -", flag))
+		}
+	}
+	if c.CPUUnits < 0 || c.CPUUnits > maxCPUUnits {
+		errs = append(errs, fmt.Errorf("cpu units must be between 0 and %d, got %d", maxCPUUnits, c.CPUUnits))
+	}
+	if vcpus := c.CPUCores * c.CPUSockets; c.CPULimit < 0 || c.CPULimit > vcpus {
+		errs = append(errs, fmt.Errorf("cpu limit must be between 0 and the %d vCPUs assigned, got %d", vcpus, c.CPULimit))
+	}
+
+	if c.MemoryMB < 1 {
+		errs = append(errs, fmt.Errorf("memory must be positive, got %d MB", c.MemoryMB))
+	}
+	if c.BalloonMinMB < 0 || c.BalloonMinMB >= c.MemoryMB {
+		errs = append(errs, fmt.Errorf("balloon minimum must be between 0 and %d MB, got %d", c.MemoryMB-1, c.BalloonMinMB))
+	}
+	if c.Hugepages != "" {
+		size, ok := hugepageSizesMB[c.Hugepages]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("hugepages must be one of 2, 1024 or any, got %q", c.Hugepages))
+		case c.MemoryMB%size != 0:
+			errs = append(errs, fmt.Errorf("memory (%d MB) must be a multiple of the %d MB hugepage size", c.MemoryMB, size))
+		}
+		if !c.NUMA {
+			errs = append(errs, errors.New("hugepages require NUMA to be enabled"))
+		}
+		if c.BalloonMinMB > 0 {
+			errs = append(errs, errors.New("hugepages cannot be combined with memory ballooning"))
+		}
+	}
+
+	switch c.BIOS {
+	case "ovmf", "seabios":
+	default:
+		errs = append(errs, fmt.Errorf("bios must be ovmf or seabios, got %q", c.BIOS))
+	}
+	if c.Machine == "" {
+		errs = append(errs, errors.New("machine type must not be empty"))
+	}
+
+	if len(c.PCIDevices) > maxPCIDevices {
+		errs = append(errs, fmt.Errorf("at most %d PCI devices can be passed through, got %d", maxPCIDevices, len(c.PCIDevices)))
+	}
+	for i, dev := range c.PCIDevices {
+		if (dev.ID == "") == (dev.Mapping == "") {
+			errs = append(errs, fmt.Errorf("pci device %d must set exactly one of id or mapping", i))
+		}
+		if dev.PCIe && !isQ35(c.Machine) {
+			errs = append(errs, fmt.Errorf("pci device %d uses PCIe, which requires the q35 machine type", i))
+		}
+	}
+	if len(c.PCIDevices) > 0 && c.BalloonMinMB > 0 {
+		errs = append(errs, errors.New("PCI passthrough pins guest memory and cannot be combined with ballooning"))
+	}
+
+	if len(c.USBDevices) > maxUSBDevices {
+		errs = append(errs, fmt.Errorf("at most %d USB devices can be passed through, got %d", maxUSBDevices, len(c.USBDevices)))
+	}
+	for i, dev := range c.USBDevices {
+		if (dev.Host == "") == (dev.Mapping == "") {
+			errs = append(errs, fmt.Errorf("usb device %d must set exactly one of host or mapping", i))
+		}
+	}
+
+	if c.IPv6Address != "" {
+		if ip, _, err := net.ParseCIDR(c.IPv6Address); err != nil || ip.To4() != nil {
+			errs = append(errs, fmt.Errorf("ipv6 address %q must be an IPv6 address in CIDR notation", c.IPv6Address))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// isQ35 reports whether a machine type is a q35 variant (e.g. "q35",
+// "pc-q35-8.1").
+func isQ35(machine string) bool {
+	return strings.Contains(machine, "q35")
+}
+
+// buildCPU returns the CPU block. Units and limit are left to Proxmox
+// defaults when zero.
+func buildCPU(cfg Config) *proxmox.VirtualMachineCpuArgs {
+	cpu := &proxmox.VirtualMachineCpuArgs{
+		Cores:   pulumi.Int(cfg.CPUCores),
+		Sockets: pulumi.Int(cfg.CPUSockets),
+		Type:    pulumi.String(cfg.CPUType),
+		Numa:    pulumi.Bool(cfg.NUMA),
+	}
+	if len(cfg.CPUFlags) > 0 {
+		cpu.Flags = pulumi.ToStringArray(cfg.CPUFlags)
+	}
+	if cfg.CPUUnits > 0 {
+		cpu.Units = pulumi.Int(cfg.CPUUnits)
+	}
+	if cfg.CPULimit > 0 {
+		cpu.Limit = pulumi.Int(cfg.CPULimit)
+	}
+	return cpu
+}
+
+// buildMemory returns the memory block. Floating memory is the balloon
+// minimum; zero disables the balloon device entirely.
+func buildMemory(cfg Config) *proxmox.VirtualMachineMemoryArgs {
+	mem := &proxmox.VirtualMachineMemoryArgs{
+		Dedicated: pulumi.Int(cfg.MemoryMB),
+		Floating:  pulumi.Int(cfg.BalloonMinMB),
+	}
+	if cfg.Hugepages != "" {
+		mem.Hugepages = pulumi.String(cfg.Hugepages)
+	}
+	return mem
+}
+
+// applyHardware sets the optional VM blocks that must be omitted entirely,
+// rather than sent empty, when unused.
+func applyHardware(args *proxmox.VirtualMachineArgs, cfg Config) {
+	// EFI disk required for UEFI/OVMF firmware.
+	if cfg.BIOS == "ovmf" {
+		args.EfiDisk = &proxmox.VirtualMachineEfiDiskArgs{
+			DatastoreId:     pulumi.String(cfg.StoragePool),
+			FileFormat:      pulumi.String("raw"),
+			PreEnrolledKeys: pulumi.Bool(false),
+			Type:            pulumi.String("4m"),
+		}
+	}
+	if len(cfg.PCIDevices) > 0 {
+		args.Hostpcis = buildHostPCIs(cfg.PCIDevices)
+	}
+	if len(cfg.USBDevices) > 0 {
+		args.Usbs = buildUSBs(cfg.USBDevices)
+	}
+
+	// The provider has no first-class RNG block, so the VirtIO RNG device is
+	// attached through raw QEMU arguments. These require the Proxmox API
+	// token to belong to root@pam.
+	if cfg.VirtIORNG {
+		args.KvmArguments = pulumi.String("-object rng-random,filename=/dev/urandom,id=rng0 -device virtio-rng-pci,rng=rng0")
+	}
+}
+
+// buildHostPCIs maps passthrough PCI devices onto hostpci0..hostpciN slots.
+func buildHostPCIs(devices []PCIDevice) proxmox.VirtualMachineHostpciArray {
+	var result proxmox.VirtualMachineHostpciArray
+	for i, dev := range devices {
+		args := &proxmox.VirtualMachineHostpciArgs{
+			Device: pulumi.String(fmt.Sprintf("hostpci%d", i)),
+			Pcie:   pulumi.Bool(dev.PCIe),
+			Rombar: pulumi.Bool(!dev.HideROM),
+		}
+		if dev.ID != "" {
+			args.Id = pulumi.String(dev.ID)
+		} else {
+			args.Mapping = pulumi.String(dev.Mapping)
+		}
+		result = append(result, args)
+	}
+	return result
+}
+
+// buildUSBs maps passthrough USB devices onto the VM's USB ports.
+func buildUSBs(devices []USBDevice) proxmox.VirtualMachineUsbArray {
+	var result proxmox.VirtualMachineUsbArray
+	for _, dev := range devices {
+		args := &proxmox.VirtualMachineUsbArgs{
+			Usb3: pulumi.Bool(dev.USB3),
+		}
+		if dev.Host != "" {
+			args.Host = pulumi.String(dev.Host)
+		} else {
+			args.Mapping = pulumi.String(dev.Mapping)
+		}
+		result = append(result, args)
+	}
+	return result
+}
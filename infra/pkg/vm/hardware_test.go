@@ -0,0 +1,91 @@
+package vm
+
+import "testing"
+
+// validConfig returns the hardware of the dev stack's VM.
+func validConfig() Config {
+	return Config{
+		CPUCores:   16,
+		CPUSockets: 1,
+		CPUType:    "host",
+		MemoryMB:   32768,
+		BIOS:       "ovmf",
+		Machine:    "q35",
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("Validate() of the base config = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr bool
+	}{
+		{"no cores", func(c *Config) { c.CPUCores = 0 }, true},
+		{"no sockets", func(c *Config) { c.CPUSockets = 0 }, true},
+		{"empty cpu type", func(c *Config) { c.CPUType = "" }, true},
+		{"cpu flags", func(c *Config) { c.CPUFlags = []string{"+aes", "-pcid"} }, false},
+		{"cpu flag without sign", func(c *Config) { c.CPUFlags = []string{"aes"} }, true},
+		{"cpu units at the limit", func(c *Config) { c.CPUUnits = maxCPUUnits }, false},
+		{"cpu units too high", func(c *Config) { c.CPUUnits = maxCPUUnits + 1 }, true},
+		{"cpu limit of every vCPU", func(c *Config) { c.CPUSockets = 2; c.CPULimit = 32 }, false},
+		{"cpu limit above the vCPUs", func(c *Config) { c.CPULimit = 17 }, true},
+		{"negative cpu limit", func(c *Config) { c.CPULimit = -1 }, true},
+		{"no memory", func(c *Config) { c.MemoryMB = 0 }, true},
+		{"balloon below memory", func(c *Config) { c.BalloonMinMB = 16384 }, false},
+		{"balloon equal to memory", func(c *Config) { c.BalloonMinMB = 32768 }, true},
+		{"1 GB hugepages with NUMA", func(c *Config) { c.Hugepages = "1024"; c.NUMA = true }, false},
+		{"hugepages without NUMA", func(c *Config) { c.Hugepages = "2" }, true},
+		{"unknown hugepage size", func(c *Config) { c.Hugepages = "4"; c.NUMA = true }, true},
+		{"memory not a hugepage multiple", func(c *Config) { c.Hugepages = "1024"; c.NUMA = true; c.MemoryMB = 32000 }, true},
+		{"hugepages with ballooning", func(c *Config) { c.Hugepages = "2"; c.NUMA = true; c.BalloonMinMB = 1024 }, true},
+		{"seabios", func(c *Config) { c.BIOS = "seabios" }, false},
+		{"unknown bios", func(c *Config) { c.BIOS = "uefi" }, true},
+		{"empty machine", func(c *Config) { c.Machine = "" }, true},
+		{"pcie device on a versioned q35", func(c *Config) {
+			c.Machine = "pc-q35-8.1"
+			c.PCIDevices = []PCIDevice{{ID: "0000:01:00.0", PCIe: true}}
+		}, false},
+		{"pcie device on i440fx", func(c *Config) {
+			c.Machine = "pc"
+			c.PCIDevices = []PCIDevice{{ID: "0000:01:00.0", PCIe: true}}
+		}, true},
+		{"pci device with id and mapping", func(c *Config) { c.PCIDevices = []PCIDevice{{ID: "0000:01:00.0", Mapping: "gpu"}} }, true},
+		{"pci device with neither", func(c *Config) { c.PCIDevices = []PCIDevice{{}} }, true},
+		{"pci passthrough with ballooning", func(c *Config) {
+			c.PCIDevices = []PCIDevice{{Mapping: "gpu"}}
+			c.BalloonMinMB = 1024
+		}, true},
+		{"too many pci devices", func(c *Config) { c.PCIDevices = make([]PCIDevice, maxPCIDevices+1) }, true},
+		{"usb device", func(c *Config) { c.USBDevices = []USBDevice{{Host: "1050:0407"}} }, false},
+		{"usb device with neither", func(c *Config) { c.USBDevices = []USBDevice{{}} }, true},
+	}
+	for _, tt := range tests {
+		cfg := validConfig()
+		tt.modify(&cfg)
+		if err := cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateIPv6(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"2001:db8:0:1::50/64", false},
+		{"172.22.202.50/24", true},
+		{"2001:db8::50", true},
+	}
+	for _, tt := range tests {
+		cfg := validConfig()
+		cfg.IPv6Address = tt.address
+		if err := cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate() with IPv6Address %q = %v, want error %v", tt.address, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/muhlba91/pulumi-proxmoxve/sdk/v6/go/proxmoxve/download"
+	proxmox "github.com/muhlba91/pulumi-proxmoxve/sdk/v6/go/proxmoxve/vm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// TemplateConfig describes the cloud-init template VMs are cloned from.
+type TemplateConfig struct {
+	// Proxmox node holding the template.
+	Node string
+	// Numeric VM ID of the template (Config.TemplateVMID).
+	VMID int
+	// Template name (Config.CloudInitTemplate).
+	Name string
+	// Cloud image to import as the template's disk.
+	ImageURL string
+	// SHA-512 checksum of the image. Empty skips verification.
+	ImageChecksum string
+	// Datastore with the "iso" content type the image is downloaded to.
+	ImageDatastore string
+	// Storage pool for the template's disks.
+	StoragePool string
+	// Network bridge of the template's NIC.
+	NetworkBridge string
+}
+
+// CreateTemplate downloads the cloud image and builds a template VM from
+// it, with the same firmware, controller and agent settings Provision
+// expects of a clone. Clones get their own hardware, disk sizes and
+// cloud-init user; the template only provides the root filesystem.
+func CreateTemplate(ctx *pulumi.Context, cfg TemplateConfig, opts ...pulumi.ResourceOption) (*proxmox.VirtualMachine, error) {
+	fileArgs := &download.FileArgs{
+		NodeName:    pulumi.String(cfg.Node),
+		DatastoreId: pulumi.String(cfg.ImageDatastore),
+		ContentType: pulumi.String("iso"),
+		Url:         pulumi.String(cfg.ImageURL),
+		// Proxmox only imports disks from .img files in iso storage.
+		FileName: pulumi.Sprintf("%s.img", cfg.Name),
+	}
+	if cfg.ImageChecksum != "" {
+		fileArgs.Checksum = pulumi.String(cfg.ImageChecksum)
+		fileArgs.ChecksumAlgorithm = pulumi.String("sha512")
+	}
+	image, err := download.NewFile(ctx, cfg.Name+"-image", fileArgs, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("downloading cloud image: %w", err)
+	}
+
+	template, err := proxmox.NewVirtualMachine(ctx, cfg.Name, &proxmox.VirtualMachineArgs{
+		NodeName:     pulumi.String(cfg.Node),
+		VmId:         pulumi.Int(cfg.VMID),
+		Name:         pulumi.String(cfg.Name),
+		Template:     pulumi.Bool(true),
+		Started:      pulumi.Bool(false),
+		Bios:         pulumi.String("ovmf"),
+		Machine:      pulumi.String("q35"),
+		ScsiHardware: pulumi.String("virtio-scsi-pci"),
+		Memory: &proxmox.VirtualMachineMemoryArgs{
+			Dedicated: pulumi.Int(4096),
+		},
+		Agent: &proxmox.VirtualMachineAgentArgs{
+			Enabled: pulumi.Bool(true),
+			Type:    pulumi.String("virtio"),
+		},
+		EfiDisk: &proxmox.VirtualMachineEfiDiskArgs{
+			DatastoreId:     pulumi.String(cfg.StoragePool),
+			FileFormat:      pulumi.String("raw"),
+			PreEnrolledKeys: pulumi.Bool(false),
+			Type:            pulumi.String("4m"),
+		},
+		Disks: proxmox.VirtualMachineDiskArray{
+			&proxmox.VirtualMachineDiskArgs{
+				Interface:   pulumi.String("scsi0"),
+				DatastoreId: pulumi.String(cfg.StoragePool),
+				FileId:      image.ID(),
+				Size:        pulumi.Int(3),
+				Ssd:         pulumi.Bool(true),
+				Discard:     pulumi.String("on"),
+			},
+		},
+		NetworkDevices: proxmox.VirtualMachineNetworkDeviceArray{
+			&proxmox.VirtualMachineNetworkDeviceArgs{
+				Bridge: pulumi.String(cfg.NetworkBridge),
+				Model:  pulumi.String("virtio"),
+			},
+		},
+		// Cloud-init drive; the settings come from each clone.
+		Initialization: &proxmox.VirtualMachineInitializationArgs{
+			DatastoreId: pulumi.String(cfg.StoragePool),
+			Type:        pulumi.String("nocloud"),
+		},
+		// Cloud images log to the serial console.
+		SerialDevices: proxmox.VirtualMachineSerialDeviceArray{
+			&proxmox.VirtualMachineSerialDeviceArgs{Device: pulumi.String("socket")},
+		},
+		OperatingSystem: &proxmox.VirtualMachineOperatingSystemArgs{
+			Type: pulumi.String("l26"),
+		},
+	}, append(opts, pulumi.Protect(true), pulumi.RetainOnDelete(true))...)
+	if err != nil {
+		return nil, fmt.Errorf("creating template VM: %w", err)
+	}
+	return template, nil
+}
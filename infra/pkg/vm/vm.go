@@ -4,28 +4,69 @@ package vm
 import (
 	"fmt"
 
+	"github.com/muhlba91/pulumi-proxmoxve/sdk/v6/go/proxmoxve/storage"
 	proxmox "github.com/muhlba91/pulumi-proxmoxve/sdk/v6/go/proxmoxve/vm"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
 // Config holds all tunables for the Proxmox VM.
 type Config struct {
+	// Named Proxmox cluster the VM is placed on (see package cluster).
+	// The caller passes that cluster's provider to Provision.
+	Cluster string
 	// Proxmox target node (e.g. "m0x-01").
 	Node string
 	// Numeric VM ID on the Proxmox cluster.
 	VMID int
 	// VM ID of the cloud-init template to clone from.
 	TemplateVMID int
+	// Node holding the template. Empty means Node.
+	TemplateNode string
 	// Hostname written into cloud-init.
 	Hostname string
-	// Number of CPU cores.
+	// Number of CPU cores per socket.
 	CPUCores int
+	// Number of CPU sockets.
+	CPUSockets int
+	// QEMU CPU model (e.g. "host", "x86-64-v3").
+	CPUType string
+	// Extra CPU flags, each prefixed with "+" or "-" (e.g. "+aes", "-pcid").
+	CPUFlags []string
+	// CPU weight relative to other VMs on the node. Zero keeps the Proxmox default.
+	CPUUnits int
+	// Hard cap on host CPU time in whole CPUs. Zero means unlimited.
+	CPULimit int
+	// Enable NUMA topology emulation.
+	NUMA bool
 	// Memory in megabytes.
 	MemoryMB int
+	// Hugepage size backing guest memory ("2", "1024" or "any"). Empty disables hugepages.
+	Hugepages string
+	// Minimum memory in megabytes the balloon driver may shrink to. Zero disables ballooning.
+	BalloonMinMB int
+	// Firmware ("ovmf" or "seabios").
+	BIOS string
+	// QEMU machine type (e.g. "q35", "pc").
+	Machine string
+	// Host PCI devices passed through to the guest.
+	PCIDevices []PCIDevice
+	// Host USB devices passed through to the guest.
+	USBDevices []USBDevice
+	// Attach a VirtIO RNG device fed from the host's /dev/urandom.
+	VirtIORNG bool
+	// Drop delete/replace protection. The VM holds every Forgejo repository,
+	// so this must only be set deliberately (e.g. before a planned rebuild
+	// or destroy).
+	AllowReplace bool
 	// Boot disk size in gigabytes.
 	BootDiskGB int
 	// Data disk size in gigabytes (mounted at /data by Ansible).
 	DataDiskGB int
+	// Keep the boot disk out of Proxmox storage replication, so a
+	// replication job copies only the data disk (see package dr).
+	ReplicateDataOnly bool
+	// Leave the VM stopped, e.g. as a cold standby.
+	Stopped bool
 	// Name of the cloud-init template to clone (must exist on Node).
 	CloudInitTemplate string
 	// Proxmox storage pool for disks (e.g. "local-lvm").
@@ -36,12 +77,23 @@ type Config struct {
 	IPAddress string
 	// Gateway for static IP configuration.
 	Gateway string
+	// Static IPv6 address in CIDR notation (e.g. "2001:db8:0:1::50/64").
+	// Empty leaves IPv6 unconfigured.
+	IPv6Address string
+	// Gateway for the IPv6 address.
+	IPv6Gateway string
 	// DNS nameserver.
 	Nameserver string
 	// SSH public keys injected via cloud-init (newline-separated).
 	SSHPublicKeys string
 	// Default SSH user created by cloud-init.
 	SSHUser string
+	// Cloud-init vendor data (a #cloud-config document, e.g. pinned SSH
+	// host keys). Uploaded as a snippet; empty means none. Treated as secret.
+	VendorData string
+	// Proxmox datastore with the "snippets" content type enabled, holding
+	// the vendor data file.
+	SnippetDatastore string
 }
 
 // Result contains the outputs produced after VM creation.
@@ -52,8 +104,14 @@ type Result struct {
 	IPAddress pulumi.StringOutput
 }
 
-// Provision creates a Proxmox VM by cloning a cloud-init template.
-func Provision(ctx *pulumi.Context, cfg Config) (*Result, error) {
+// Provision creates a Proxmox VM by cloning a cloud-init template. opts
+// apply to every resource it creates; pass pulumi.Provider to choose the
+// cluster (see package cluster).
+func Provision(ctx *pulumi.Context, cfg Config, opts ...pulumi.ResourceOption) (*Result, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid VM config: %w", err)
+	}
+
 	// Determine cloud-init IP config: static or DHCP.
 	useDHCP := cfg.IPAddress == ""
 
@@ -63,34 +121,43 @@ func Provision(ctx *pulumi.Context, cfg Config) (*Result, error) {
 		dnsServers = pulumi.StringArray{pulumi.String(cfg.Nameserver)}
 	}
 
-	vm, err := proxmox.NewVirtualMachine(ctx, cfg.Hostname, &proxmox.VirtualMachineArgs{
+	// Vendor data merges with the generated user data, so the user account
+	// below still applies.
+	var vendorDataFileID pulumi.StringPtrInput
+	if cfg.VendorData != "" {
+		snippet, err := uploadVendorData(ctx, cfg, opts...)
+		if err != nil {
+			return nil, err
+		}
+		vendorDataFileID = snippet.ID().ToStringPtrOutput()
+	}
+
+	templateNode := cfg.TemplateNode
+	if templateNode == "" {
+		templateNode = cfg.Node
+	}
+
+	args := &proxmox.VirtualMachineArgs{
 		NodeName: pulumi.String(cfg.Node),
 		VmId:     pulumi.Int(cfg.VMID),
 		Name:     pulumi.String(cfg.Hostname),
 
-		// UEFI firmware + q35 machine type (modern PCIe).
-		Bios:    pulumi.String("ovmf"),
-		Machine: pulumi.String("q35"),
+		// Firmware and machine type (UEFI + q35 by default).
+		Bios:    pulumi.String(cfg.BIOS),
+		Machine: pulumi.String(cfg.Machine),
 
 		// Clone from an existing cloud-init template.
 		Clone: &proxmox.VirtualMachineCloneArgs{
-			NodeName: pulumi.String(cfg.Node),
+			NodeName: pulumi.String(templateNode),
 			VmId:     pulumi.Int(cfg.TemplateVMID),
 			Full:     pulumi.Bool(true),
 		},
 
 		// CPU configuration.
-		Cpu: &proxmox.VirtualMachineCpuArgs{
-			Cores:   pulumi.Int(cfg.CPUCores),
-			Sockets: pulumi.Int(1),
-			Type:    pulumi.String("host"),
-		},
+		Cpu: buildCPU(cfg),
 
-		// Dedicated memory, ballooning disabled.
-		Memory: &proxmox.VirtualMachineMemoryArgs{
-			Dedicated: pulumi.Int(cfg.MemoryMB),
-			Floating:  pulumi.Int(0),
-		},
+		// Dedicated memory; ballooning only when a minimum is configured.
+		Memory: buildMemory(cfg),
 
 		// Enable QEMU guest agent for Proxmox integration.
 		Agent: &proxmox.VirtualMachineAgentArgs{
@@ -102,7 +169,8 @@ func Provision(ctx *pulumi.Context, cfg Config) (*Result, error) {
 		// VirtIO SCSI controller.
 		ScsiHardware: pulumi.String("virtio-scsi-pci"),
 
-		// Boot disk (root filesystem) + data disk.
+		// Boot disk (root filesystem) + data disk. Growing either is an
+		// in-place update; shrinks are refused by storage.CheckResize.
 		Disks: proxmox.VirtualMachineDiskArray{
 			// Boot disk: OS root filesystem.
 			&proxmox.VirtualMachineDiskArgs{
@@ -113,6 +181,7 @@ func Provision(ctx *pulumi.Context, cfg Config) (*Result, error) {
 				Cache:       pulumi.String("writethrough"),
 				Ssd:         pulumi.Bool(true),
 				Discard:     pulumi.String("on"),
+				Replicate:   pulumi.Bool(!cfg.ReplicateDataOnly),
 			},
 			// Data disk: persistent service data (/data), attached by Ansible.
 			&proxmox.VirtualMachineDiskArgs{
@@ -126,14 +195,6 @@ func Provision(ctx *pulumi.Context, cfg Config) (*Result, error) {
 			},
 		},
 
-		// EFI disk required for UEFI/OVMF firmware.
-		EfiDisk: &proxmox.VirtualMachineEfiDiskArgs{
-			DatastoreId:     pulumi.String(cfg.StoragePool),
-			FileFormat:      pulumi.String("raw"),
-			PreEnrolledKeys: pulumi.Bool(false),
-			Type:            pulumi.String("4m"),
-		},
-
 		// Virtio network device.
 		NetworkDevices: proxmox.VirtualMachineNetworkDeviceArray{
 			&proxmox.VirtualMachineNetworkDeviceArgs{
@@ -150,12 +211,13 @@ func Provision(ctx *pulumi.Context, cfg Config) (*Result, error) {
 				Servers: dnsServers,
 			},
 			IpConfigs: proxmox.VirtualMachineInitializationIpConfigArray{
-				buildIPConfig(useDHCP, cfg.IPAddress, cfg.Gateway),
+				buildIPConfig(useDHCP, cfg),
 			},
 			UserAccount: &proxmox.VirtualMachineInitializationUserAccountArgs{
 				Username: pulumi.String(cfg.SSHUser),
 				Keys:     pulumi.ToStringArray(splitKeys(cfg.SSHPublicKeys)),
 			},
+			VendorDataFileId: vendorDataFileID,
 		},
 
 		// Disable the empty CD-ROM drive inherited from the template clone.
@@ -171,14 +233,19 @@ func Provision(ctx *pulumi.Context, cfg Config) (*Result, error) {
 			pulumi.String("net0"),
 		},
 
-		// Start VM on creation.
-		Started: pulumi.Bool(true),
+		// Start VM on creation, unless it is a cold standby.
+		Started: pulumi.Bool(!cfg.Stopped),
 
 		// OS type hint for Proxmox (Linux 2.6+ kernel).
 		OperatingSystem: &proxmox.VirtualMachineOperatingSystemArgs{
 			Type: pulumi.String("l26"),
 		},
-	})
+	}
+
+	// EFI disk, passthrough devices and extra QEMU arguments.
+	applyHardware(args, cfg)
+
+	vm, err := proxmox.NewVirtualMachine(ctx, cfg.Hostname, args, append(opts, protectOptions(ctx, cfg)...)...)
 	if err != nil {
 		return nil, fmt.Errorf("creating proxmox VM: %w", err)
 	}
@@ -188,7 +255,7 @@ func Provision(ctx *pulumi.Context, cfg Config) (*Result, error) {
 	// guest agent. For DHCP, fall back to the guest agent's report.
 	var ipAddr pulumi.StringOutput
 	if cfg.IPAddress != "" {
-		ipAddr = pulumi.String(stripCIDR(cfg.IPAddress)).ToStringOutput()
+		ipAddr = pulumi.String(StripCIDR(cfg.IPAddress)).ToStringOutput()
 	} else {
 		ipAddr = vm.Ipv4Addresses.ApplyT(func(addrs [][]string) string {
 			for i, iface := range addrs {
@@ -212,26 +279,67 @@ func Provision(ctx *pulumi.Context, cfg Config) (*Result, error) {
 	}, nil
 }
 
-// buildIPConfig returns either a DHCP or static IP cloud-init config.
-func buildIPConfig(dhcp bool, ipAddr, gateway string) *proxmox.VirtualMachineInitializationIpConfigArgs {
-	if dhcp {
-		return &proxmox.VirtualMachineInitializationIpConfigArgs{
-			Ipv4: &proxmox.VirtualMachineInitializationIpConfigIpv4Args{
-				Address: pulumi.String("dhcp"),
-			},
-		}
+// uploadVendorData stores cfg.VendorData as a cloud-init snippet on the
+// node. The provider uploads snippets over SSH, so its SSH settings must be
+// configured alongside the API token.
+func uploadVendorData(ctx *pulumi.Context, cfg Config, opts ...pulumi.ResourceOption) (*storage.File, error) {
+	data := pulumi.ToSecret(pulumi.String(cfg.VendorData)).(pulumi.StringOutput)
+	snippet, err := storage.NewFile(ctx, cfg.Hostname+"-vendor-data", &storage.FileArgs{
+		NodeName:    pulumi.String(cfg.Node),
+		DatastoreId: pulumi.String(cfg.SnippetDatastore),
+		ContentType: pulumi.String("snippets"),
+		SourceRaw: &storage.FileSourceRawArgs{
+			Data:     data,
+			FileName: pulumi.Sprintf("%s-vendor-data.yaml", cfg.Hostname),
+		},
+	}, append(opts, pulumi.AdditionalSecretOutputs([]string{"sourceRaw"}))...)
+	if err != nil {
+		return nil, fmt.Errorf("uploading cloud-init vendor data: %w", err)
+	}
+	return snippet, nil
+}
+
+// protectOptions guards the VM against accidental deletion. Clone settings
+// only matter at creation time, so changes to them are ignored rather than
+// planned as a replacement. A change to VMID or Node still forces one,
+// which Protect turns into a hard error unless AllowReplace is set.
+func protectOptions(ctx *pulumi.Context, cfg Config) []pulumi.ResourceOption {
+	opts := []pulumi.ResourceOption{
+		pulumi.IgnoreChanges([]string{"clone"}),
 	}
-	return &proxmox.VirtualMachineInitializationIpConfigArgs{
+	if cfg.AllowReplace {
+		ctx.Log.Warn(fmt.Sprintf("allow_replace is set: VM %q is not protected against deletion", cfg.Hostname), nil)
+		return opts
+	}
+	return append(opts, pulumi.Protect(true), pulumi.RetainOnDelete(true))
+}
+
+// buildIPConfig returns the cloud-init IP config: DHCP or a static IPv4
+// address, plus the static IPv6 address when one is set.
+func buildIPConfig(dhcp bool, cfg Config) *proxmox.VirtualMachineInitializationIpConfigArgs {
+	ipConfig := &proxmox.VirtualMachineInitializationIpConfigArgs{
 		Ipv4: &proxmox.VirtualMachineInitializationIpConfigIpv4Args{
-			Address: pulumi.String(ipAddr),
-			Gateway: pulumi.String(gateway),
+			Address: pulumi.String("dhcp"),
 		},
 	}
+	if !dhcp {
+		ipConfig.Ipv4 = &proxmox.VirtualMachineInitializationIpConfigIpv4Args{
+			Address: pulumi.String(cfg.IPAddress),
+			Gateway: pulumi.String(cfg.Gateway),
+		}
+	}
+	if cfg.IPv6Address != "" {
+		ipConfig.Ipv6 = &proxmox.VirtualMachineInitializationIpConfigIpv6Args{
+			Address: pulumi.String(cfg.IPv6Address),
+			Gateway: pulumi.String(cfg.IPv6Gateway),
+		}
+	}
+	return ipConfig
 }
 
-// stripCIDR removes the "/prefix" suffix from a CIDR address (e.g.
+// StripCIDR removes the "/prefix" suffix from a CIDR address (e.g.
 // "172.22.202.50/24" -> "172.22.202.50").
-func stripCIDR(addr string) string {
+func StripCIDR(addr string) string {
 	for i := 0; i < len(addr); i++ {
 		if addr[i] == '/' {
 			return addr[:i]
@@ -0,0 +1,84 @@
+"""CrossGuard policy pack with the team's guardrails for the Antarctica stack.
+
+The engine loads it on every `pulumi preview` and `pulumi up` given
+`--policy-pack policy`; the antarctica-infra commands always pass it. The
+rules live in rules.py. The names, descriptions and levels must match
+infra/pkg/policy, which the CLI uses for -list and -disable-policy.
+"""
+
+from pulumi_policy import (
+    EnforcementLevel,
+    PolicyConfigSchema,
+    PolicyPack,
+    ReportViolation,
+    ResourceValidationArgs,
+    ResourceValidationPolicy,
+    StackValidationArgs,
+    StackValidationPolicy,
+)
+
+import rules
+
+
+def resource_policy(name, description, rule):
+    def validate(args: ResourceValidationArgs, report_violation: ReportViolation):
+        for msg in rule(args.resource_type, args.props, args.opts.protect):
+            report_violation(msg)
+
+    return ResourceValidationPolicy(
+        name=name,
+        description=description,
+        enforcement_level=EnforcementLevel.MANDATORY,
+        validate=validate,
+    )
+
+
+def validate_database_ports(args: StackValidationArgs, report_violation: ReportViolation):
+    for r in args.resources:
+        for msg in rules.validate_firewall_rules(r.resource_type, r.props):
+            report_violation(msg, r.urn)
+    for msg in rules.validate_host_firewall(args.get_config().get("hostFirewallPorts", [])):
+        report_violation(msg)
+
+
+PolicyPack(
+    name="antarctica",
+    enforcement_level=EnforcementLevel.MANDATORY,
+    policies=[
+        resource_policy(
+            "dns-min-ttl",
+            f"DNS record TTLs must be at least {rules.MIN_DNS_TTL} seconds",
+            rules.validate_ttl,
+        ),
+        resource_policy(
+            "vm-min-memory",
+            f"VMs must have at least {rules.MIN_MEMORY_MB} MB of dedicated memory",
+            rules.validate_memory,
+        ),
+        resource_policy(
+            "vm-ssh-user-not-root",
+            "The cloud-init SSH user must not be root",
+            rules.validate_ssh_user,
+        ),
+        resource_policy(
+            "vm-ovmf-efi-disk",
+            "VMs using OVMF firmware must have an EFI disk",
+            rules.validate_efi_disk,
+        ),
+        StackValidationPolicy(
+            name="no-public-database-ports",
+            description="PostgreSQL ports 5432/5433 must not be open to any source",
+            enforcement_level=EnforcementLevel.MANDATORY,
+            validate=validate_database_ports,
+            # The ports Ansible opens on the host firewall, set by the CLI.
+            config_schema=PolicyConfigSchema(
+                properties={"hostFirewallPorts": {"type": "array", "items": {"type": "integer"}}},
+            ),
+        ),
+        resource_policy(
+            "vm-protected",
+            "VMs must have the Protect resource option",
+            rules.validate_protect,
+        ),
+    ],
+)
@@ -0,0 +1,148 @@
+"""The rules of the Antarctica policy pack.
+
+Each rule takes a resource as the engine reports it to the pack (its type,
+its input properties and whether it has the Protect option) and returns one
+message per problem; resources of other types return nothing. The rules do
+not import the policy SDK, so test_rules.py runs without it.
+"""
+
+# Resource types the rules inspect.
+TYPE_VIRTUAL_MACHINE = "proxmoxve:VM/virtualMachine:VirtualMachine"
+TYPE_FIREWALL_RULES = "proxmoxve:Network/firewallRules:FirewallRules"
+TYPE_SECURITY_GROUP = "proxmoxve:Network/firewallSecurityGroup:FirewallSecurityGroup"
+TYPE_RECORD_SET = "gcp:dns/recordSet:RecordSet"
+
+# The lowest TTL in seconds a record may have.
+MIN_DNS_TTL = 60
+# The least dedicated memory a VM may have.
+MIN_MEMORY_MB = 4096
+
+# Ports that must never be reachable from outside the host.
+DATABASE_PORTS = [
+    5432,  # PostgreSQL
+    5433,  # PostgreSQL (second cluster during major upgrades)
+]
+
+
+def validate_ttl(resource_type, props, protect=False):
+    if resource_type != TYPE_RECORD_SET:
+        return []
+    ttl = number(props.get("ttl"))
+    if ttl is None:
+        # Unset means the provider default of 300.
+        return []
+    if ttl < MIN_DNS_TTL:
+        return [f"TTL is {ttl}, the minimum is {MIN_DNS_TTL}"]
+    return []
+
+
+def validate_memory(resource_type, props, protect=False):
+    if resource_type != TYPE_VIRTUAL_MACHINE:
+        return []
+    # Proxmox defaults to 512 MB when memory is unset.
+    dedicated = number(field(props, "memory", "dedicated"))
+    if dedicated is None:
+        dedicated = 512
+    if dedicated < MIN_MEMORY_MB:
+        return [f"dedicated memory is {dedicated} MB, the minimum is {MIN_MEMORY_MB} MB"]
+    return []
+
+
+def validate_ssh_user(resource_type, props, protect=False):
+    if resource_type != TYPE_VIRTUAL_MACHINE:
+        return []
+    if field(props, "initialization", "userAccount", "username") == "root":
+        return ["cloud-init creates the SSH user as root; use an unprivileged user with sudo"]
+    return []
+
+
+def validate_efi_disk(resource_type, props, protect=False):
+    if resource_type != TYPE_VIRTUAL_MACHINE or props.get("bios") != "ovmf":
+        return []
+    if props.get("efiDisk") is None:
+        return ["bios is ovmf but the VM has no EFI disk, so UEFI variables are lost on every restart"]
+    return []
+
+
+def validate_firewall_rules(resource_type, props, protect=False):
+    if resource_type not in (TYPE_FIREWALL_RULES, TYPE_SECURITY_GROUP):
+        return []
+    msgs = []
+    for i, rule in enumerate(props.get("rules") or []):
+        if not isinstance(rule, dict) or rule.get("action") != "ACCEPT":
+            continue
+        if rule.get("enabled") is False:
+            continue
+        if not is_any_source(rule.get("source")):
+            continue
+        for port in DATABASE_PORTS:
+            if port_list_includes(rule.get("dport"), port):
+                msgs.append(f"rule {i} accepts port {port} from any source")
+    return msgs
+
+
+def validate_host_firewall(ports):
+    """Checks the ports Ansible opens to everyone on the host firewall.
+
+    They are not a resource, so the engine never sees them; the CLI passes
+    network.FirewallPorts in the pack config instead.
+    """
+    return [
+        f"port {port} is opened to every source on the host firewall"
+        for port in ports
+        if port in DATABASE_PORTS
+    ]
+
+
+def validate_protect(resource_type, props, protect=False):
+    if resource_type != TYPE_VIRTUAL_MACHINE or protect:
+        return []
+    return ["the VM is not protected (allow_replace is set); it holds every Forgejo repository"]
+
+
+def field(props, *path):
+    """Walks nested objects in props, returning None when a level is missing."""
+    value = props
+    for key in path:
+        if not isinstance(value, dict):
+            return None
+        value = value.get(key)
+    return value
+
+
+def number(value):
+    """Converts a number (or numeric string) to an int, or None."""
+    if isinstance(value, bool):
+        return None
+    if isinstance(value, (int, float)):
+        return int(value)
+    if isinstance(value, str):
+        try:
+            return int(value)
+        except ValueError:
+            return None
+    return None
+
+
+def is_any_source(source):
+    """Reports whether a Proxmox rule source matches every address."""
+    return (source or "").strip() in ("", "0.0.0.0/0", "::/0")
+
+
+def port_list_includes(ports, port):
+    """Reports whether a Proxmox port list ("22,80:90") covers port.
+
+    An empty list matches every port.
+    """
+    if not (ports or "").strip():
+        return True
+    for part in ports.split(","):
+        lo, sep, hi = part.strip().partition(":")
+        try:
+            start = int(lo)
+            end = int(hi) if sep else start
+        except ValueError:
+            continue
+        if start <= port <= end:
+            return True
+    return False
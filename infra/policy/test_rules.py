@@ -0,0 +1,92 @@
+import unittest
+
+import rules
+
+
+def firewall(*rule_list, resource_type=rules.TYPE_FIREWALL_RULES):
+    return resource_type, {"rules": list(rule_list)}
+
+
+class PortListIncludesTest(unittest.TestCase):
+    def test_port_list_includes(self):
+        tests = [
+            ("", 5432, True),
+            (" ", 5432, True),
+            (None, 5432, True),
+            ("5432", 5432, True),
+            ("22,80,443", 5432, False),
+            ("22, 5432", 5432, True),
+            ("5000:6000", 5432, True),
+            ("5433:6000", 5432, False),
+            ("5000:5432", 5432, True),
+            ("http,5432", 5432, True),
+            ("5000:x", 5432, False),
+        ]
+        for ports, port, want in tests:
+            with self.subTest(ports=ports, port=port):
+                self.assertEqual(rules.port_list_includes(ports, port), want)
+
+
+class ValidateFirewallRulesTest(unittest.TestCase):
+    def test_validate_firewall_rules(self):
+        tests = [
+            ("open to all", firewall({"action": "ACCEPT", "dport": "5432"}), 1),
+            ("both ports in a range", firewall({"action": "ACCEPT", "dport": "5000:6000", "source": "0.0.0.0/0"}), 2),
+            ("every port", firewall({"action": "ACCEPT"}), 2),
+            ("restricted source", firewall({"action": "ACCEPT", "dport": "5432", "source": "172.22.202.0/24"}), 0),
+            ("drop rule", firewall({"action": "DROP", "dport": "5432"}), 0),
+            ("disabled rule", firewall({"action": "ACCEPT", "dport": "5432", "enabled": False}), 0),
+            ("other ports", firewall({"action": "ACCEPT", "dport": "22,443"}), 0),
+            ("security group", firewall(
+                {"action": "ACCEPT", "dport": "5433", "source": "::/0"},
+                resource_type=rules.TYPE_SECURITY_GROUP,
+            ), 1),
+            ("other resource type", firewall({"action": "ACCEPT"}, resource_type=rules.TYPE_RECORD_SET), 0),
+        ]
+        for name, (resource_type, props), want in tests:
+            with self.subTest(name):
+                self.assertEqual(len(rules.validate_firewall_rules(resource_type, props)), want)
+
+    def test_validate_host_firewall(self):
+        self.assertEqual(len(rules.validate_host_firewall([22, 443, 5432])), 1)
+        self.assertEqual(rules.validate_host_firewall([22, 443]), [])
+
+
+class ValidateVirtualMachineTest(unittest.TestCase):
+    vm = {
+        "memory": {"dedicated": 2048.0},
+        "bios": "ovmf",
+        "efiDisk": {},
+        "initialization": {"userAccount": {"username": "antarctica"}},
+    }
+
+    def test_memory(self):
+        self.assertEqual(len(rules.validate_memory(rules.TYPE_VIRTUAL_MACHINE, self.vm)), 1)
+        self.assertEqual(len(rules.validate_memory(rules.TYPE_VIRTUAL_MACHINE, {})), 1)
+        self.assertEqual(rules.validate_memory(rules.TYPE_VIRTUAL_MACHINE, {"memory": {"dedicated": 8192}}), [])
+
+    def test_ssh_user(self):
+        self.assertEqual(rules.validate_ssh_user(rules.TYPE_VIRTUAL_MACHINE, self.vm), [])
+        root = {"initialization": {"userAccount": {"username": "root"}}}
+        self.assertEqual(len(rules.validate_ssh_user(rules.TYPE_VIRTUAL_MACHINE, root)), 1)
+
+    def test_efi_disk(self):
+        self.assertEqual(rules.validate_efi_disk(rules.TYPE_VIRTUAL_MACHINE, self.vm), [])
+        self.assertEqual(len(rules.validate_efi_disk(rules.TYPE_VIRTUAL_MACHINE, {"bios": "ovmf"})), 1)
+        self.assertEqual(rules.validate_efi_disk(rules.TYPE_VIRTUAL_MACHINE, {"bios": "seabios"}), [])
+
+    def test_protect(self):
+        self.assertEqual(len(rules.validate_protect(rules.TYPE_VIRTUAL_MACHINE, self.vm, False)), 1)
+        self.assertEqual(rules.validate_protect(rules.TYPE_VIRTUAL_MACHINE, self.vm, True), [])
+        self.assertEqual(rules.validate_protect(rules.TYPE_RECORD_SET, {}, False), [])
+
+
+class ValidateTTLTest(unittest.TestCase):
+    def test_ttl(self):
+        self.assertEqual(len(rules.validate_ttl(rules.TYPE_RECORD_SET, {"ttl": 30})), 1)
+        self.assertEqual(rules.validate_ttl(rules.TYPE_RECORD_SET, {"ttl": "300"}), [])
+        self.assertEqual(rules.validate_ttl(rules.TYPE_RECORD_SET, {}), [])
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,7 @@
+// Package integration runs the Antarctica Pulumi program end to end
+// against the Proxmox VE and Cloud DNS stand-ins (pveapitest and
+// clouddnstest), with a throwaway local backend. The tests need the pulumi
+// CLI and are built only with the integration tag:
+//
+//	go test -tags integration ./test/integration/
+package integration
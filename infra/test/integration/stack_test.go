@@ -0,0 +1,216 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nerdsrun/antarctica/infra/pkg/clouddns/clouddnstest"
+	"github.com/nerdsrun/antarctica/infra/pkg/dns"
+	"github.com/nerdsrun/antarctica/infra/pkg/outputs"
+	"github.com/nerdsrun/antarctica/infra/pkg/pveapi/pveapitest"
+	"github.com/nerdsrun/antarctica/infra/pkg/stackconfig"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+const (
+	node       = "m0x-01"
+	project    = "antarctica-integration"
+	zone       = "private-dev-nerds-run"
+	domain     = "dev.nerds.run"
+	vmID       = 200
+	templateID = 9000
+	ipAddress  = "172.22.202.50"
+)
+
+// TestStackLifecycle deploys the program, checks the VM and DNS records it
+// created, previews again expecting no changes, and destroys the stack.
+func TestStackLifecycle(t *testing.T) {
+	if _, err := exec.LookPath("pulumi"); err != nil {
+		t.Skip("pulumi CLI not installed")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	defer cancel()
+
+	pve := pveapitest.NewServer(node)
+	defer pve.Close()
+	pve.AddTemplate(templateID, "debian-12-cloudinit", map[string]string{
+		"cores":     "2",
+		"memory":    "2048",
+		"scsi0":     "sharedx:base-9000-disk-0,size=3G",
+		"ide2":      "sharedx:vm-9000-cloudinit,media=cdrom",
+		"net0":      "virtio=BC:24:11:5E:7A:01,bridge=vmbr0",
+		"scsihw":    "virtio-scsi-pci",
+		"serial0":   "socket",
+		"agent":     "enabled=1",
+		"boot":      "order=scsi0",
+		"ostype":    "l26",
+		"ipconfig0": "ip=dhcp",
+	})
+
+	gdns := clouddnstest.NewServer(project)
+	defer gdns.Close()
+	gdns.AddZone(zone, domain+".", "private")
+
+	stack := newStack(ctx, t, pve, gdns)
+
+	res, err := stack.Up(ctx, optup.ProgressStreams(testWriter{t}))
+	if err != nil {
+		t.Fatalf("pulumi up: %v\nunhandled Proxmox requests: %v", err, pve.Unhandled())
+	}
+
+	// Stack outputs.
+	out, err := outputs.Decode(res.Outputs[outputs.Key].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.VMIP != ipAddress {
+		t.Errorf("vm_ip = %q, want %q", out.VMIP, ipAddress)
+	}
+	if out.DiskSizes["scsi1"] != 20 {
+		t.Errorf("disk_sizes[scsi1] = %d, want 20", out.DiskSizes["scsi1"])
+	}
+
+	// The VM was cloned, configured and started.
+	vm, ok := pve.VM(vmID)
+	if !ok {
+		t.Fatalf("VM %d was not created", vmID)
+	}
+	if vm.Status != "running" {
+		t.Errorf("VM status = %q, want running", vm.Status)
+	}
+	for key, want := range map[string]string{
+		"name":   "antarctica-it",
+		"memory": "4096",
+		"cores":  "2",
+	} {
+		if got := vm.Config[key]; got != want {
+			t.Errorf("VM config %s = %q, want %q", key, got, want)
+		}
+	}
+
+	// Every service has an A record pointing at the VM.
+	records := map[string]int{}
+	for _, rs := range gdns.RecordSets(zone) {
+		if rs.Type == "A" && len(rs.Rrdatas) == 1 && rs.Rrdatas[0] == ipAddress {
+			records[rs.Name] = rs.TTL
+		}
+	}
+	sites, err := dns.LoadSites(filepath.Join("..", "..", stackconfig.DefaultServicesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range sites {
+		ttl, ok := records[rec.FQDN(domain)]
+		if !ok {
+			t.Errorf("no A record %s -> %s", rec.FQDN(domain), ipAddress)
+		} else if ttl != dns.DefaultTTL {
+			t.Errorf("%s TTL = %d, want %d", rec.FQDN(domain), ttl, dns.DefaultTTL)
+		}
+	}
+
+	// The fakes round-trip what the providers wrote.
+	if _, err := stack.Preview(ctx, optpreview.ExpectNoChanges(), optpreview.ProgressStreams(testWriter{t})); err != nil {
+		t.Errorf("second preview expected no changes: %v", err)
+	}
+
+	if _, err := stack.Destroy(ctx, optdestroy.ProgressStreams(testWriter{t})); err != nil {
+		t.Fatalf("pulumi destroy: %v", err)
+	}
+	if _, ok := pve.VM(vmID); ok {
+		t.Errorf("VM %d still exists after destroy", vmID)
+	}
+	if rs := gdns.RecordSets(zone); len(rs) != 0 {
+		t.Errorf("%d record sets left after destroy: %v", len(rs), rs)
+	}
+}
+
+// newStack creates a stack of the program in a temporary workspace with a
+// file backend, configured against the fakes. The project settings point
+// at the real program, so no Pulumi.<stack>.yaml lands in the source tree.
+func newStack(ctx context.Context, t *testing.T, pve *pveapitest.Server, gdns *clouddnstest.Server) auto.Stack {
+	t.Helper()
+
+	programDir, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	workDir := t.TempDir()
+	mainDir, err := filepath.Rel(workDir, programDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendDir := t.TempDir()
+
+	ws, err := auto.NewLocalWorkspace(ctx,
+		auto.WorkDir(workDir),
+		auto.Project(workspace.Project{
+			Name:    "antarctica",
+			Runtime: workspace.NewProjectRuntimeInfo("go", nil),
+			Main:    mainDir,
+		}),
+		auto.EnvVars(map[string]string{
+			"PULUMI_BACKEND_URL":        "file://" + filepath.ToSlash(backendDir),
+			"PULUMI_CONFIG_PASSPHRASE":  "integration",
+			"PULUMI_SKIP_UPDATE_CHECK":  "true",
+			"GOOGLE_OAUTH_ACCESS_TOKEN": "integration-token",
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack, err := auto.UpsertStack(ctx, "integration", ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := auto.ConfigMap{
+		"proxmoxve:endpoint":    {Value: pve.Endpoint()},
+		"proxmoxve:apiToken":    {Value: "root@pam!integration=00000000-0000-0000-0000-000000000000", Secret: true},
+		"proxmoxve:insecure":    {Value: "true"},
+		"gcp:project":           {Value: project},
+		"gcp:dnsCustomEndpoint": {Value: gdns.BaseURL()},
+
+		"antarctica:proxmox_node":        {Value: node},
+		"antarctica:vm_id":               {Value: strconv.Itoa(vmID)},
+		"antarctica:template_vm_id":      {Value: strconv.Itoa(templateID)},
+		"antarctica:hostname":            {Value: "antarctica-it"},
+		"antarctica:cpu_cores":           {Value: "2"},
+		"antarctica:memory_mb":           {Value: "4096"},
+		"antarctica:boot_disk_gb":        {Value: "10"},
+		"antarctica:data_disk_gb":        {Value: "20"},
+		"antarctica:storage_pool":        {Value: "sharedx"},
+		"antarctica:ip_address":          {Value: ipAddress + "/24"},
+		"antarctica:gateway":             {Value: "172.22.202.1"},
+		"antarctica:ssh_public_keys":     {Value: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOG+XlD2ybhcm+VrmC8B7D3TnFymWRQ3GYsfqm+vN+S5 integration"},
+		"antarctica:gcp_dns_zone":        {Value: zone},
+		"antarctica:dns_domain":          {Value: domain},
+		"antarctica:pin_host_keys":       {Value: "false"},
+		"antarctica:allow_replace":       {Value: "true"},
+		"antarctica:cloud_init_template": {Value: "debian-12-cloudinit"},
+	}
+	if err := stack.SetAllConfig(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+	return stack
+}
+
+// testWriter sends Pulumi progress output to the test log.
+type testWriter struct {
+	t *testing.T
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Helper()
+	w.t.Log(string(p))
+	return len(p), nil
+}